@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats and the file extensions which mark them
+var compressionExts = map[string]string{
+	".br":  "brotli",
+	".bz2": "bzip2",
+	".gz":  "gzip",
+	".zst": "zstd",
+}
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Is a --decompress format known?
+func validCompression(format string) bool {
+	switch format {
+	case "", "none", "gzip", "bzip2", "zstd", "brotli":
+		return true
+	}
+	return false
+}
+
+// Detect the compression of an input from its magic bytes. Brotli streams
+// have no magic bytes so they're recognized by the .br extension of a file.
+func detectCompression(r *bufio.Reader, name string) string {
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(magic, zstdMagic):
+		return "zstd"
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return "bzip2"
+	case compressionExts[strings.ToLower(filepath.Ext(name))] == "brotli":
+		return "brotli"
+	}
+	return "none"
+}
+
+// Decompress an input in the given format, or the detected one if format is
+// empty. The returned reader must be closed to release the decompressor.
+func decompress(r io.Reader, format string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if format == "" {
+		var name string
+		if f, ok := r.(*os.File); ok {
+			name = f.Name()
+		}
+		format = detectCompression(br, name)
+	}
+	switch format {
+	case "gzip":
+		return gzip.NewReader(br)
+	case "bzip2":
+		return ioutil.NopCloser(bzip2.NewReader(br)), nil
+	case "zstd":
+		d, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case "brotli":
+		return ioutil.NopCloser(brotli.NewReader(br)), nil
+	case "none":
+		return ioutil.NopCloser(br), nil
+	}
+	return nil, fmt.Errorf("Unknown compression format '%s'", format)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compressWith(t *testing.T, format string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch format {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		var err error
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			t.Fatal(err)
+		}
+	case "brotli":
+		w = brotli.NewWriter(&buf)
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	page := []byte(`<html><body><p>caf&eacute;</p></body></html>`)
+	for _, test := range []struct {
+		input  string
+		format string
+	}{
+		{"gzip", ""},
+		{"zstd", ""},
+		{"gzip", "gzip"},
+		{"brotli", "brotli"},
+	} {
+		r, err := decompress(bytes.NewReader(compressWith(t, test.input, page)), test.format)
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(data, page) {
+			t.Errorf("%s: expected %q got %q (%v)", test.input, page, data, err)
+		}
+	}
+
+	// uncompressed input passes through untouched
+	r, _ := decompress(bytes.NewReader(page), "")
+	if data, _ := ioutil.ReadAll(r); !bytes.Equal(data, page) {
+		t.Errorf("expected %q got %q", page, data)
+	}
+}
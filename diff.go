@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// A line of a diff: ' ' for a line both sides have, '-' for a removed line
+// and '+' for an added one
+type diffOp struct {
+	Kind byte
+	Line []byte
+}
+
+// Diffs with more changes than this are shown as replacing every line
+// between the first and last change, to bound the memory used
+const maxDiffEdits = 2000
+
+// Write the differences between two versions of a file in the unified format
+// with three lines of context, or nothing if they're the same
+func unifiedDiff(w io.Writer, name string, a, b []byte) {
+	ops := diffLines(splitLines(a), splitLines(b))
+	const context = 3
+	header := false
+	// the number of lines of each side before an op
+	aLine, bLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			aLine, bLine = aLine+1, bLine+1
+			i++
+			continue
+		}
+		// a hunk runs until there are more than two contexts of equal lines
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops) && j-end <= 2*context; j++ {
+			if ops[j].Kind != ' ' {
+				end = j + 1
+			}
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:stop] {
+			if op.Kind != '+' {
+				aCount++
+			}
+			if op.Kind != '-' {
+				bCount++
+			}
+		}
+		if !header {
+			fmt.Fprintf(w, "--- %s\n+++ %s\n", name, name)
+			header = true
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range ops[start:stop] {
+			fmt.Fprintf(w, "%c%s", op.Kind, op.Line)
+			if !bytes.HasSuffix(op.Line, []byte("\n")) {
+				fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+		}
+		aLine, bLine = aStart+aCount, bStart+bCount
+		i = stop
+	}
+}
+
+// The start and length of one side of a hunk, as diff -u writes them
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Split content into lines, keeping their newlines
+func splitLines(content []byte) [][]byte {
+	lines := [][]byte{}
+	for len(content) > 0 {
+		i := bytes.IndexByte(content, '\n') + 1
+		if i == 0 {
+			i = len(content)
+		}
+		lines = append(lines, content[:i])
+		content = content[i:]
+	}
+	return lines
+}
+
+// Find the lines to remove from a and add to make b, using Myers' algorithm
+// on the lines between the common prefix and suffix
+func diffLines(a, b [][]byte) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && bytes.Equal(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		bytes.Equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+	ops := []diffOp{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+func myersDiff(a, b [][]byte) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// the furthest x reached on each diagonal k in -d..d, for each d
+	trace := [][]int{}
+	for d := 0; d <= max; d++ {
+		if d > maxDiffEdits {
+			ops := []diffOp{}
+			for _, line := range a {
+				ops = append(ops, diffOp{'-', line})
+			}
+			for _, line := range b {
+				ops = append(ops, diffOp{'+', line})
+			}
+			return ops
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		if v[offset+n-m] >= n && n-m >= -d && n-m <= d {
+			return myersPath(trace, a, b)
+		}
+	}
+	return nil
+}
+
+// Follow the furthest reaching paths back from the end of both sides
+func myersPath(trace [][]int, a, b [][]byte) []diffOp {
+	reversed := []diffOp{}
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			reversed = append(reversed, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, diffOp{' ', a[x-1]})
+		x, y = x-1, y-1
+	}
+	ops := make([]diffOp, len(reversed))
+	for i, op := range reversed {
+		ops[len(ops)-1-i] = op
+	}
+	return ops
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n"
+	expected := strings.Join([]string{
+		"--- a.html",
+		"+++ a.html",
+		"@@ -1,6 +1,6 @@",
+		" 1",
+		" 2",
+		"-3",
+		"+three",
+		" 4",
+		" 5",
+		" 6",
+		"@@ -13,4 +13,5 @@",
+		" 13",
+		" 14",
+		" 15",
+		"-16",
+		`\ No newline at end of file`,
+		"+16",
+		"+17",
+		"",
+	}, "\n")
+
+	var buf bytes.Buffer
+	unifiedDiff(&buf, "a.html", []byte(a), []byte(b))
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	unifiedDiff(&buf, "a.html", []byte(a), []byte(a))
+	if buf.Len() != 0 {
+		t.Errorf("expected no diff for the same content got\n%s", buf.String())
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"regexp"
 	"strings"
 
@@ -17,21 +19,65 @@ func init() {
 }
 
 type Displayer interface {
-	Display([]*html.Node)
+	Display(io.Writer, []*html.Node)
 }
 
+// A Displayer which can fail, such as a template, returns the error from
+// Execute rather than printing it, so the job displaying the nodes fails
+type executer interface {
+	Execute(io.Writer, []*html.Node) error
+}
+
+// Display nodes, returning the error of a displayer which can fail
+func display(d Displayer, w io.Writer, nodes []*html.Node) error {
+	if e, ok := d.(executer); ok {
+		return e.Execute(w, nodes)
+	}
+	d.Display(w, nodes)
+	return nil
+}
+
+var errUnknownDisplayer = fmt.Errorf("Unknown displayer")
+
 func ParseDisplayer(cmd string) error {
-	attrRe := regexp.MustCompile(`attr\{([a-zA-Z\-]+)\}`)
-	if cmd == "text{}" {
+	attrRe := regexp.MustCompile(`^(attr|abs)\{([^{},\s]+(,[^{},\s]+)*)\}$`)
+	if cmd == "forms{}" || cmd == "forms{urlencoded}" {
+		pupDisplayer = FormsDisplayer{URLEncoded: cmd == "forms{urlencoded}"}
+	} else if cmd == "schema{}" {
+		pupDisplayer = SchemaDisplayer{}
+	} else if cmd == "links{}" || cmd == "links{json}" {
+		pupDisplayer = LinksDisplayer{JSON: cmd == "links{json}"}
+	} else if cmd == "html{}" || cmd == "html{min}" {
+		pupDisplayer = HTMLDisplayer{Minify: cmd == "html{min}"}
+	} else if cmd == "inner{}" || cmd == "inner{min}" {
+		pupDisplayer = HTMLDisplayer{Inner: true, Minify: cmd == "inner{min}"}
+	} else if cmd == "markdown{}" || cmd == "markdown{gfm}" {
+		pupDisplayer = MarkdownDisplayer{GFM: true}
+	} else if cmd == "markdown{commonmark}" {
+		pupDisplayer = MarkdownDisplayer{GFM: false}
+	} else if cmd == "render{}" {
+		pupDisplayer = RenderDisplayer{}
+	} else if strings.HasPrefix(cmd, "tmpl{") && strings.HasSuffix(cmd, "}") {
+		t, err := ParseTemplate(cmd[len("tmpl{") : len(cmd)-1])
+		if err != nil {
+			return err
+		}
+		pupDisplayer = t
+	} else if cmd == "path{}" || cmd == "path{css}" || cmd == "path{xpath}" {
+		pupDisplayer = PathDisplayer{XPath: cmd == "path{xpath}"}
+	} else if cmd == "loc{}" {
+		pupDisplayer = LocDisplayer{}
+	} else if cmd == "text{}" {
 		pupDisplayer = TextDisplayer{}
 	} else if cmd == "json{}" {
 		pupDisplayer = JSONDisplayer{}
-	} else if match := attrRe.FindAllStringSubmatch(cmd, -1); len(match) == 1 {
+	} else if match := attrRe.FindStringSubmatch(cmd); match != nil {
 		pupDisplayer = AttrDisplayer{
-			Attr: match[0][1],
+			Attrs:   strings.Split(match[2], ","),
+			Resolve: match[1] == "abs",
 		}
 	} else {
-		return fmt.Errorf("Unknown displayer")
+		return errUnknownDisplayer
 	}
 	return nil
 }
@@ -48,6 +94,16 @@ func isVoidElement(n *html.Node) bool {
 	return false
 }
 
+// Look up the value of an attribute on a node
+func getAttr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
 var (
 	// Colors
 	tagColor     *color.Color = color.New(color.FgCyan)
@@ -60,15 +116,15 @@ var (
 type TreeDisplayer struct {
 }
 
-func (t TreeDisplayer) Display(nodes []*html.Node) {
+func (t TreeDisplayer) Display(w io.Writer, nodes []*html.Node) {
 	for _, node := range nodes {
-		t.printNode(node, 0)
+		t.printNode(w, node, 0)
 	}
 }
 
 // The <pre> tag indicates that the text within it should always be formatted
 // as is. See https://github.com/ericchiang/pup/issues/33
-func (t TreeDisplayer) printPre(n *html.Node) {
+func (t TreeDisplayer) printPre(w io.Writer, n *html.Node) {
 	switch n.Type {
 	case html.TextNode:
 		s := n.Data
@@ -78,48 +134,50 @@ func (t TreeDisplayer) printPre(n *html.Node) {
 				s = html.EscapeString(s)
 			}
 		}
-		fmt.Print(s)
+		fmt.Fprint(w, s)
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			t.printPre(c)
+			t.printPre(w, c)
 		}
 	case html.ElementNode:
-		fmt.Printf("<%s", n.Data)
+		fmt.Fprintf(w, "<%s", n.Data)
 		for _, a := range n.Attr {
-			val := a.Val
+			val := resolveAttr(n, a, false)
 			if pupEscapeHTML {
 				val = html.EscapeString(val)
 			}
-			fmt.Printf(` %s="%s"`, a.Key, val)
+			fmt.Fprintf(w, ` %s="%s"`, a.Key, val)
 		}
-		fmt.Print(">")
+		fmt.Fprint(w, ">")
 		if !isVoidElement(n) {
 			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				t.printPre(c)
+				t.printPre(w, c)
 			}
-			fmt.Printf("</%s>", n.Data)
+			fmt.Fprintf(w, "</%s>", n.Data)
 		}
 	case html.CommentNode:
 		data := n.Data
 		if pupEscapeHTML {
 			data = html.EscapeString(data)
 		}
-		fmt.Printf("<!--%s-->\n", data)
+		fmt.Fprintf(w, "<!--%s-->\n", data)
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			t.printPre(c)
+			t.printPre(w, c)
 		}
 	case html.DoctypeNode, html.DocumentNode:
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			t.printPre(c)
+			t.printPre(w, c)
 		}
 	}
 }
 
 // Print a node and all of it's children to `maxlevel`.
-func (t TreeDisplayer) printNode(n *html.Node, level int) {
+func (t TreeDisplayer) printNode(w io.Writer, n *html.Node, level int) {
 	switch n.Type {
 	case html.TextNode:
 		s := n.Data
-		if pupEscapeHTML {
+		if isCDATA(n) {
+			s = "<![CDATA[" + s + "]]>"
+		} else if pupEscapeHTML {
 			// don't escape javascript
 			if n.Parent == nil || n.Parent.DataAtom != atom.Script {
 				s = html.EscapeString(s)
@@ -127,95 +185,118 @@ func (t TreeDisplayer) printNode(n *html.Node, level int) {
 		}
 		s = strings.TrimSpace(s)
 		if s != "" {
-			t.printIndent(level)
-			fmt.Println(s)
+			t.printIndent(w, level)
+			fmt.Fprintln(w, s)
 		}
 	case html.ElementNode:
-		t.printIndent(level)
+		t.printIndent(w, level)
 		// TODO: allow pre with color
-		if n.DataAtom == atom.Pre && !pupPrintColor && pupPreformatted {
-			t.printPre(n)
-			fmt.Println()
+		if n.DataAtom == atom.Pre && !pupPrintColor && pupPreformatted && !pupXML {
+			t.printPre(w, n)
+			fmt.Fprintln(w)
 			return
 		}
 		if pupPrintColor {
-			tokenColor.Print("<")
-			tagColor.Printf("%s", n.Data)
+			fmt.Fprint(w, tokenColor.SprintFunc()("<"))
+			fmt.Fprint(w, tagColor.SprintfFunc()("%s", n.Data))
 		} else {
-			fmt.Printf("<%s", n.Data)
+			fmt.Fprintf(w, "<%s", n.Data)
 		}
 		for _, a := range n.Attr {
-			val := a.Val
+			val := resolveAttr(n, a, false)
 			if pupEscapeHTML {
 				val = html.EscapeString(val)
 			}
+			key := a.Key
+			if pupXML {
+				key = attrName(a)
+			}
 			if pupPrintColor {
-				fmt.Print(" ")
-				attrKeyColor.Printf("%s", a.Key)
-				tokenColor.Print("=")
-				quoteColor.Printf(`"%s"`, val)
+				fmt.Fprint(w, " ")
+				fmt.Fprint(w, attrKeyColor.SprintfFunc()("%s", key))
+				fmt.Fprint(w, tokenColor.SprintFunc()("="))
+				fmt.Fprint(w, quoteColor.SprintfFunc()(`"%s"`, val))
 			} else {
-				fmt.Printf(` %s="%s"`, a.Key, val)
+				fmt.Fprintf(w, ` %s="%s"`, key, val)
 			}
 		}
+		// XML elements without children are self-closing
+		closes, end := !isVoidElement(n), ">"
+		if pupXML {
+			closes = n.FirstChild != nil
+			if !closes {
+				end = "/>"
+			}
+		}
+		if pos, ok := nodePosition(n); ok && pupWithPositions {
+			end += fmt.Sprintf(" <!-- %d:%d -->", pos.Line, pos.Column)
+		}
 		if pupPrintColor {
-			tokenColor.Println(">")
+			fmt.Fprintln(w, tokenColor.SprintFunc()(end))
 		} else {
-			fmt.Println(">")
+			fmt.Fprintln(w, end)
 		}
-		if !isVoidElement(n) {
-			t.printChildren(n, level+1)
-			t.printIndent(level)
+		if closes {
+			t.printChildren(w, n, level+1)
+			t.printIndent(w, level)
 			if pupPrintColor {
-				tokenColor.Print("</")
-				tagColor.Printf("%s", n.Data)
-				tokenColor.Println(">")
+				fmt.Fprint(w, tokenColor.SprintFunc()("</"))
+				fmt.Fprint(w, tagColor.SprintfFunc()("%s", n.Data))
+				fmt.Fprintln(w, tokenColor.SprintFunc()(">"))
 			} else {
-				fmt.Printf("</%s>\n", n.Data)
+				fmt.Fprintf(w, "</%s>\n", n.Data)
 			}
 		}
 	case html.CommentNode:
-		t.printIndent(level)
-		data := n.Data
-		if pupEscapeHTML {
+		t.printIndent(w, level)
+		data, format := n.Data, "<!--%s-->\n"
+		if isProcInst(n) {
+			format = "<?%s?>\n"
+		} else if pupEscapeHTML {
 			data = html.EscapeString(data)
 		}
 		if pupPrintColor {
-			commentColor.Printf("<!--%s-->\n", data)
+			fmt.Fprint(w, commentColor.SprintfFunc()(format, data))
 		} else {
-			fmt.Printf("<!--%s-->\n", data)
+			fmt.Fprintf(w, format, data)
 		}
-		t.printChildren(n, level)
-	case html.DoctypeNode, html.DocumentNode:
-		t.printChildren(n, level)
+		t.printChildren(w, n, level)
+	case html.DoctypeNode:
+		if pupXML {
+			t.printIndent(w, level)
+			fmt.Fprintf(w, "<!DOCTYPE %s>\n", n.Data)
+		}
+		t.printChildren(w, n, level)
+	case html.DocumentNode:
+		t.printChildren(w, n, level)
 	}
 }
 
-func (t TreeDisplayer) printChildren(n *html.Node, level int) {
+func (t TreeDisplayer) printChildren(w io.Writer, n *html.Node, level int) {
 	if pupMaxPrintLevel > -1 {
 		if level >= pupMaxPrintLevel {
-			t.printIndent(level)
-			fmt.Println("...")
+			t.printIndent(w, level)
+			fmt.Fprintln(w, "...")
 			return
 		}
 	}
 	child := n.FirstChild
 	for child != nil {
-		t.printNode(child, level)
+		t.printNode(w, child, level)
 		child = child.NextSibling
 	}
 }
 
-func (t TreeDisplayer) printIndent(level int) {
+func (t TreeDisplayer) printIndent(w io.Writer, level int) {
 	for ; level > 0; level-- {
-		fmt.Print(pupIndentString)
+		fmt.Fprint(w, pupIndentString)
 	}
 }
 
 // Print the text of a node
 type TextDisplayer struct{}
 
-func (t TextDisplayer) Display(nodes []*html.Node) {
+func (t TextDisplayer) Display(w io.Writer, nodes []*html.Node) {
 	for _, node := range nodes {
 		if node.Type == html.TextNode {
 			data := node.Data
@@ -225,7 +306,7 @@ func (t TextDisplayer) Display(nodes []*html.Node) {
 					data = html.EscapeString(data)
 				}
 			}
-			fmt.Println(data)
+			fmt.Fprintln(w, data)
 		}
 		children := []*html.Node{}
 		child := node.FirstChild
@@ -233,28 +314,71 @@ func (t TextDisplayer) Display(nodes []*html.Node) {
 			children = append(children, child)
 			child = child.NextSibling
 		}
-		t.Display(children)
+		t.Display(w, children)
 	}
 }
 
-// Print the attribute of a node
+// Print the attributes of a node. Multiple attributes are printed tab
+// separated and a trailing '*' matches every attribute with that prefix,
+// printed as key=value. With Resolve set URLs are always resolved, as they
+// are for the abs{} display function.
 type AttrDisplayer struct {
-	Attr string
+	Attrs   []string
+	Resolve bool
 }
 
-func (a AttrDisplayer) Display(nodes []*html.Node) {
+func (a AttrDisplayer) Display(w io.Writer, nodes []*html.Node) {
 	for _, node := range nodes {
-		attributes := node.Attr
-		for _, attr := range attributes {
-			if attr.Key == a.Attr {
-				val := attr.Val
-				if pupEscapeHTML {
-					val = html.EscapeString(val)
+		fields := []string{}
+		found := false
+		for _, key := range a.Attrs {
+			vals := []string{}
+			if strings.HasSuffix(key, "*") {
+				prefix := strings.ToLower(key[:len(key)-1])
+				for _, attr := range node.Attr {
+					name := attrName(attr)
+					if strings.HasPrefix(strings.ToLower(name), prefix) {
+						vals = append(vals, name+"="+resolveAttr(node, attr, a.Resolve))
+					}
+				}
+			} else {
+				for _, attr := range node.Attr {
+					if strings.EqualFold(attrName(attr), key) {
+						vals = append(vals, resolveAttr(node, attr, a.Resolve))
+					}
 				}
-				fmt.Printf("%s\n", val)
 			}
+			if len(vals) == 0 {
+				if pupPrintMissing {
+					vals = append(vals, pupMissing)
+				} else {
+					vals = append(vals, "")
+				}
+			} else {
+				found = true
+			}
+			fields = append(fields, vals...)
+		}
+		// nodes without any of the attributes are skipped unless a
+		// placeholder keeps the rows aligned
+		if !found && !pupPrintMissing {
+			continue
 		}
+		for i, field := range fields {
+			if pupEscapeHTML {
+				fields[i] = html.EscapeString(field)
+			}
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(fields, "\t"))
+	}
+}
+
+// The name of an attribute including its namespace prefix, e.g. xlink:href
+func attrName(attr html.Attribute) string {
+	if attr.Namespace != "" {
+		return attr.Namespace + ":" + attr.Key
 	}
+	return attr.Key
 }
 
 // Print nodes as a JSON list
@@ -265,14 +389,18 @@ func jsonify(node *html.Node) map[string]interface{} {
 	vals := map[string]interface{}{}
 	if len(node.Attr) > 0 {
 		for _, attr := range node.Attr {
+			val := resolveAttr(node, attr, false)
 			if pupEscapeHTML {
-				vals[attr.Key] = html.EscapeString(attr.Val)
+				vals[attrName(attr)] = html.EscapeString(val)
 			} else {
-				vals[attr.Key] = attr.Val
+				vals[attrName(attr)] = val
 			}
 		}
 	}
-	vals["tag"] = node.DataAtom.String()
+	vals["tag"] = node.Data
+	if pos, ok := nodePosition(node); ok && pupWithPositions {
+		vals["pos"] = pos
+	}
 	children := []interface{}{}
 	for child := node.FirstChild; child != nil; child = child.NextSibling {
 		switch child.Type {
@@ -312,23 +440,182 @@ func jsonify(node *html.Node) map[string]interface{} {
 	return vals
 }
 
-func (j JSONDisplayer) Display(nodes []*html.Node) {
+func (j JSONDisplayer) Display(w io.Writer, nodes []*html.Node) {
 	var data []byte
 	var err error
 	jsonNodes := []map[string]interface{}{}
 	for _, node := range nodes {
-		jsonNodes = append(jsonNodes, jsonify(node))
+		vals := jsonify(node)
+		documentSource(node).addTo(vals)
+		jsonNodes = append(jsonNodes, vals)
 	}
 	data, err = json.MarshalIndent(&jsonNodes, "", pupIndentString)
 	if err != nil {
 		panic("Could not jsonify nodes")
 	}
-	fmt.Printf("%s\n", data)
+	fmt.Fprintf(w, "%s\n", data)
 }
 
 // Print the number of features returned
 type NumDisplayer struct{}
 
-func (d NumDisplayer) Display(nodes []*html.Node) {
-	fmt.Println(len(nodes))
+func (d NumDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	fmt.Fprintln(w, len(nodes))
+}
+
+// Print the markup of nodes as-is, without re-indenting. With Inner set only
+// the children of each node are printed.
+type HTMLDisplayer struct {
+	Inner  bool
+	Minify bool
+	// Escape as little as the serialization algorithm does, for edits
+	Exact bool
+	// Leave out everything the markup doesn't need, for --minify
+	Shorten bool
+}
+
+func (h HTMLDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	for _, node := range nodes {
+		var buf bytes.Buffer
+		preserve := preservesSpace(node.Parent)
+		if pupXML {
+			if h.Inner {
+				for c := node.FirstChild; c != nil; c = c.NextSibling {
+					writeXML(&buf, c)
+				}
+			} else {
+				writeXML(&buf, node)
+			}
+		} else if h.Inner {
+			for c := node.FirstChild; c != nil; c = c.NextSibling {
+				writeHTML(&buf, c, htmlStyle{h.Minify, h.Exact, h.Shorten}, preserve || preservesSpace(node))
+			}
+		} else {
+			writeHTML(&buf, node, htmlStyle{h.Minify, h.Exact, h.Shorten}, preserve)
+		}
+		fmt.Fprintln(w, buf.String())
+	}
+}
+
+// How writeHTML serializes nodes
+type htmlStyle struct {
+	// Drop comments and collapse whitespace outside of preformatted elements
+	Minify bool
+	// Escape only what the serialization algorithm does, whatever
+	// pupEscapeHTML is, so edited documents change as little as they can
+	Exact bool
+	// When minifying, also leave out whitespace next to blocks, optional end
+	// tags, attribute quotes and boolean attribute values, keeping
+	// conditional comments
+	Shorten bool
+}
+
+var (
+	exactTextEscaper = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", "<", "&lt;", ">", "&gt;")
+	exactAttrEscaper = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", `"`, "&quot;")
+)
+
+// Serialize a node following the HTML fragment serialization algorithm.
+// Text is only escaped if pupEscapeHTML is set, attribute values always have
+// their quotes escaped so the markup stays well formed. When minifying,
+// comments are dropped and whitespace collapsed outside of preformatted
+// elements.
+func writeHTML(buf *bytes.Buffer, n *html.Node, style htmlStyle, preserve bool) {
+	switch n.Type {
+	case html.TextNode:
+		s := n.Data
+		if !isRawTextElement(n.Parent) {
+			if style.Minify && !preserve {
+				s = collapseSpace(s)
+				if style.Shorten {
+					s = trimBlockSpace(n, s)
+				}
+				if bytes.HasSuffix(buf.Bytes(), []byte(" ")) {
+					s = strings.TrimPrefix(s, " ")
+				}
+			}
+			if style.Exact {
+				s = exactTextEscaper.Replace(s)
+			} else if pupEscapeHTML {
+				s = html.EscapeString(s)
+			}
+		}
+		buf.WriteString(s)
+	case html.CommentNode:
+		if !style.Minify || style.Shorten && isConditionalComment(n) {
+			buf.WriteString("<!--" + n.Data + "-->")
+		}
+	case html.DoctypeNode:
+		// html.Render knows how to write public and system identifiers
+		html.Render(buf, n)
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeHTML(buf, c, style, preserve)
+		}
+	case html.ElementNode:
+		buf.WriteString("<" + n.Data)
+		for _, a := range n.Attr {
+			if style.Minify && style.Shorten {
+				writeShortAttr(buf, a)
+				continue
+			}
+			val := a.Val
+			if style.Exact {
+				val = exactAttrEscaper.Replace(val)
+			} else if pupEscapeHTML {
+				val = html.EscapeString(val)
+			} else {
+				val = strings.Replace(val, `"`, "&quot;", -1)
+			}
+			buf.WriteString(" " + attrName(a) + `="` + val + `"`)
+		}
+		buf.WriteString(">")
+		if isVoidElement(n) {
+			return
+		}
+		// a leading newline in these elements is dropped when parsed
+		if c := n.FirstChild; c != nil && c.Type == html.TextNode && strings.HasPrefix(c.Data, "\n") {
+			switch n.DataAtom {
+			case atom.Pre, atom.Listing, atom.Textarea:
+				buf.WriteString("\n")
+			}
+		}
+		preserve = preserve || preservesSpace(n)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeHTML(buf, c, style, preserve)
+		}
+		if n.DataAtom == atom.Plaintext {
+			// <plaintext> can't be closed
+			return
+		}
+		if style.Minify && style.Shorten && canOmitEndTag(n) {
+			return
+		}
+		buf.WriteString("</" + n.Data + ">")
+	}
+}
+
+// Are the text children of this element written without escaping?
+func isRawTextElement(n *html.Node) bool {
+	if n == nil || n.Type != html.ElementNode {
+		return false
+	}
+	switch n.DataAtom {
+	case atom.Iframe, atom.Noembed, atom.Noframes, atom.Noscript,
+		atom.Plaintext, atom.Script, atom.Style, atom.Xmp:
+		return true
+	}
+	return false
+}
+
+// Does whitespace matter inside this node or any of its ancestors?
+func preservesSpace(n *html.Node) bool {
+	for ; n != nil; n = n.Parent {
+		switch n.DataAtom {
+		case atom.Pre, atom.Listing, atom.Textarea, atom.Plaintext,
+			atom.Script, atom.Style, atom.Xmp:
+			return true
+		}
+	}
+	return false
 }
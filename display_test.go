@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// Select nodes from a document and return what a display function prints
+// for them
+func displayString(t *testing.T, d Displayer, input, selector string) string {
+	root, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds, err := ParseCommands(selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	d.Display(&buf, SelectNodes(root, selectorFuncs))
+	return buf.String()
+}
+
+func TestParseAttrDisplayer(t *testing.T) {
+	defer func(d Displayer) { pupDisplayer = d }(pupDisplayer)
+	tests := []struct {
+		cmd   string
+		attrs []string
+	}{
+		{"attr{href}", []string{"href"}},
+		{"attr{href,data-*,xlink:href}", []string{"href", "data-*", "xlink:href"}},
+		{"attr{href,}", nil},
+		{"attr{a b}", nil},
+		{"attr{}", nil},
+		{"xattr{href}", nil},
+	}
+	for _, test := range tests {
+		pupDisplayer = nil
+		err := ParseDisplayer(test.cmd)
+		if test.attrs == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.cmd)
+			}
+			continue
+		}
+		d, ok := pupDisplayer.(AttrDisplayer)
+		if err != nil || !ok || strings.Join(d.Attrs, "|") != strings.Join(test.attrs, "|") {
+			t.Errorf("%s: expected attributes %q got %#v, %v", test.cmd, test.attrs, pupDisplayer, err)
+		}
+	}
+}
+
+func TestAttrDisplayer(t *testing.T) {
+	defer func(print bool, missing string) {
+		pupPrintMissing, pupMissing = print, missing
+	}(pupPrintMissing, pupMissing)
+	input := `<a href="/a" title="x&amp;y" data-id=1 data-Kind=b>a</a>
+		<a title=z>b</a>
+		<a HREF="/c" data-id=2>c</a>
+		<svg><a xlink:href="/d"></a></svg>`
+	tests := []struct {
+		attrs    []string
+		missing  string
+		expected string
+	}{
+		{[]string{"href"}, "", "/a\n/c\n"},
+		{[]string{"HREF"}, "", "/a\n/c\n"},
+		{[]string{"href", "title"}, "", "/a\tx&amp;y\n\tz\n/c\t\n"},
+		{[]string{"href", "title"}, "-", "/a\tx&amp;y\n-\tz\n/c\t-\n-\t-\n"},
+		{[]string{"data-*"}, "", "data-id=1\tdata-kind=b\ndata-id=2\n"},
+		{[]string{"title", "data-*"}, "-", "x&amp;y\tdata-id=1\tdata-kind=b\nz\t-\n-\tdata-id=2\n-\t-\n"},
+		{[]string{"xlink:href"}, "", "/d\n"},
+		{[]string{"xlink:*"}, "", "xlink:href=/d\n"},
+	}
+	for _, test := range tests {
+		pupPrintMissing, pupMissing = test.missing != "", test.missing
+		output := displayString(t, AttrDisplayer{Attrs: test.attrs}, input, "a")
+		if output != test.expected {
+			t.Errorf("%q missing %q: expected\n%q\ngot\n%q", test.attrs, test.missing, test.expected, output)
+		}
+	}
+}
+
+func TestHTMLDisplayer(t *testing.T) {
+	input := `<div id=a class="x  y">
+	<p>a &amp; <b>b</b><!-- c --></p>
+	<pre>
+  d  e</pre>
+	<br><img src="f.png" alt='"g"'>
+	<script>if (a < b) {}</script>
+</div><p id=b>h</p>`
+	tests := []struct {
+		displayer HTMLDisplayer
+		selector  string
+		expected  string
+	}{
+		{HTMLDisplayer{}, "p", "<p>a &amp; <b>b</b><!-- c --></p>\n<p id=\"b\">h</p>\n"},
+		{HTMLDisplayer{Inner: true}, "p", "a &amp; <b>b</b><!-- c -->\nh\n"},
+		{HTMLDisplayer{Inner: true}, "#b", "h\n"},
+		{HTMLDisplayer{}, "img", "<img src=\"f.png\" alt=\"&#34;g&#34;\">\n"},
+		{HTMLDisplayer{}, "script", "<script>if (a < b) {}</script>\n"},
+		{HTMLDisplayer{}, "pre", "<pre>  d  e</pre>\n"},
+		{HTMLDisplayer{Inner: true}, "pre", "  d  e\n"},
+		{HTMLDisplayer{Minify: true}, "#a",
+			"<div id=\"a\" class=\"x  y\"> <p>a &amp; <b>b</b></p> <pre>  d  e</pre> <br><img src=\"f.png\" alt=\"&#34;g&#34;\"> <script>if (a < b) {}</script> </div>\n"},
+		{HTMLDisplayer{Inner: true, Minify: true}, "#a p", "a &amp; <b>b</b>\n"},
+		{HTMLDisplayer{}, "span", ""},
+	}
+	for _, test := range tests {
+		output := displayString(t, test.displayer, input, test.selector)
+		if output != test.expected {
+			t.Errorf("%#v %s: expected\n%q\ngot\n%q", test.displayer, test.selector, test.expected, output)
+		}
+	}
+}
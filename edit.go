@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// A change made to the nodes a selector selects, given with a flag such as
+// --delete or --set-attr
+type editAction struct {
+	Kind          string
+	SelectorFuncs []SelectorFunc
+	// The value of the flag after the selector, e.g. name=value
+	Arg string
+}
+
+// The number of arguments an edit flag takes, including the selector
+var editFlags = map[string]int{
+	"--delete":      1,
+	"--unwrap":      1,
+	"--set-attr":    2,
+	"--remove-attr": 2,
+	"--replace":     2,
+	"--wrap":        2,
+	"--set-text":    2,
+}
+
+// Parse the arguments of an edit flag
+func parseEdit(flag, selector, arg string) (editAction, error) {
+	edit := editAction{Kind: strings.TrimPrefix(flag, "--"), Arg: arg}
+	cmds, err := ParseCommands(selector)
+	if err != nil {
+		return edit, err
+	}
+	if len(cmds) == 0 {
+		return edit, fmt.Errorf("Option '%s' requires a selector", flag)
+	}
+	if edit.SelectorFuncs, err = ParseSelectorFuncs(cmds); err != nil {
+		return edit, fmt.Errorf("Selector parsing error: %s", err.Error())
+	}
+	switch edit.Kind {
+	case "set-attr":
+		if i := strings.Index(arg, "="); i < 1 {
+			return edit, fmt.Errorf("Argument for '%s' must be name=value", flag)
+		}
+	case "wrap":
+		if wrapper := parseEditHTML(arg, nil); len(wrapper) != 1 || wrapper[0].Type != html.ElementNode {
+			return edit, fmt.Errorf("Argument for '%s' must be a single element", flag)
+		}
+	}
+	return edit, nil
+}
+
+// Parse the markup given to --replace or --wrap as it would be parsed within
+// the parent of a node, or within a <body> if parent is nil
+func parseEditHTML(markup string, parent *html.Node) []*html.Node {
+	context := parent
+	if context == nil || context.Type != html.ElementNode {
+		context = &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	}
+	// reading a string can't fail
+	nodes, _ := html.ParseFragment(strings.NewReader(markup), context)
+	return nodes
+}
+
+// Apply edits to a document in order and return the number of nodes changed
+func applyEdits(root *html.Node, edits []editAction) int {
+	changed := 0
+	for _, edit := range edits {
+		for _, n := range SelectNodes(root, edit.SelectorFuncs) {
+			if n.Type == html.ElementNode && n.Parent != nil && applyEdit(n, edit) {
+				changed++
+			}
+		}
+	}
+	return changed
+}
+
+// Apply an edit to a node, returning whether anything changed
+func applyEdit(n *html.Node, edit editAction) bool {
+	parent := n.Parent
+	switch edit.Kind {
+	case "delete":
+		parent.RemoveChild(n)
+	case "unwrap":
+		for c := n.FirstChild; c != nil; c = n.FirstChild {
+			n.RemoveChild(c)
+			parent.InsertBefore(c, n)
+		}
+		parent.RemoveChild(n)
+	case "set-attr":
+		i := strings.Index(edit.Arg, "=")
+		key, val := edit.Arg[:i], edit.Arg[i+1:]
+		for i, a := range n.Attr {
+			if attrName(a) == key {
+				if a.Val == val {
+					return false
+				}
+				n.Attr[i].Val = val
+				return true
+			}
+		}
+		n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+	case "remove-attr":
+		attrs := []html.Attribute{}
+		for _, a := range n.Attr {
+			if attrName(a) != edit.Arg {
+				attrs = append(attrs, a)
+			}
+		}
+		if len(attrs) == len(n.Attr) {
+			return false
+		}
+		n.Attr = attrs
+	case "replace":
+		for _, c := range parseEditHTML(edit.Arg, parent) {
+			parent.InsertBefore(c, n)
+		}
+		parent.RemoveChild(n)
+	case "wrap":
+		wrapper := parseEditHTML(edit.Arg, nil)[0]
+		parent.InsertBefore(wrapper, n)
+		parent.RemoveChild(n)
+		innermostElement(wrapper).AppendChild(n)
+	case "set-text":
+		for c := n.FirstChild; c != nil; c = n.FirstChild {
+			n.RemoveChild(c)
+		}
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: edit.Arg})
+	}
+	return true
+}
+
+// The element a wrapped node goes in, which like jQuery's wrap is the
+// innermost first element of the wrapper
+func innermostElement(n *html.Node) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return innermostElement(c)
+		}
+	}
+	return n
+}
+
+// Apply the edits to a file and write it back, or with --diff print how it
+// would change. Files are replaced by renaming a temporary file over them,
+// after copying them to a backup if --in-place was given a suffix.
+func editInPlace(w io.Writer, name string) error {
+	if inputName(name) == stdinName {
+		return fmt.Errorf("%s can't be edited in place", stdinName)
+	}
+	original, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(bytes.NewReader(original))
+	if pupDecompress != "none" && detectCompression(br, name) != "none" {
+		return fmt.Errorf("%s: compressed files can't be edited in place", name)
+	}
+	if isMHTML(br) {
+		return fmt.Errorf("%s: MHTML archives can't be edited in place", name)
+	}
+	var root *html.Node
+	var source *sourceEdits
+	if pupXML || pupMinify {
+		// the whole document is written again
+		root, err = parseDecoded(br, pupCharset, "")
+	} else {
+		root, source, err = parseSource(br)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err.Error())
+	}
+	defer releaseDocument(root)
+	var changed int
+	if source != nil {
+		changed = source.applyEdits(root, pupEdits)
+	} else {
+		changed = applyEdits(root, pupEdits)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d changed\n", name, changed)
+	if changed == 0 {
+		// leave the file as it was rather than reserializing it
+		return nil
+	}
+
+	var edited []byte
+	if source != nil {
+		var ok bool
+		if edited, ok = spliceEdited(root, source, original); !ok {
+			fmt.Fprintf(os.Stderr, "Warning: %s: the edits can't be made to the source, writing the whole document\n", name)
+		}
+	}
+	if edited == nil {
+		var buf bytes.Buffer
+		if pupXML {
+			writeXML(&buf, root)
+		} else {
+			writeHTML(&buf, root, htmlStyle{Minify: pupMinify, Exact: true, Shorten: pupMinify}, false)
+		}
+		if bytes.HasSuffix(original, []byte("\n")) && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+		if edited, err = encodeEdited(root, matchLineEndings(original, buf.Bytes())); err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+
+	if pupDiff {
+		unifiedDiff(w, name, original, edited)
+		return nil
+	}
+	if pupBackupSuffix != "" {
+		if err := replaceFile(name+pupBackupSuffix, original, stat.Mode()); err != nil {
+			return err
+		}
+	}
+	return replaceFile(name, edited, stat.Mode())
+}
+
+// Parse a file to edit in place, keeping its decoded content so the edits
+// can be made to its source
+func parseSource(r io.Reader) (*html.Node, *sourceEdits, error) {
+	r, info, err := decodeHTML(r, pupCharset, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := parseContent(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	setDocumentCharset(root, info)
+	return root, newSourceEdits(root, content), nil
+}
+
+func parseContent(content []byte) (*html.Node, error) {
+	if pupFragment != "" {
+		return parseFragment(bytes.NewReader(content), pupFragment)
+	}
+	return html.Parse(bytes.NewReader(content))
+}
+
+// Make the edits to the source of a document, leaving the rest of it as it
+// was. They're only kept if the edited source parses to the edited document,
+// as it is and once encoded, and the source can be encoded back to the bytes
+// it was read from.
+func spliceEdited(root *html.Node, source *sourceEdits, original []byte) ([]byte, bool) {
+	if !source.ok {
+		return nil, false
+	}
+	pieces, ok := source.pieces()
+	if !ok {
+		return nil, false
+	}
+	var text bytes.Buffer
+	for _, p := range pieces {
+		text.WriteString(p.Text)
+	}
+	reparsed, err := parseContent(text.Bytes())
+	if err != nil {
+		return nil, false
+	}
+	var expected, got bytes.Buffer
+	writeHTML(&expected, root, htmlStyle{Exact: true}, false)
+	writeHTML(&got, reparsed, htmlStyle{Exact: true}, false)
+	if expected.String() != got.String() {
+		return nil, false
+	}
+
+	info := documentCharset(root)
+	encoded, err := encodeSource(info.Name, source.content)
+	if err != nil || !bytes.HasSuffix(original, encoded) {
+		return nil, false
+	}
+	// a byte order mark the decoder removed
+	bom := original[:len(original)-len(encoded)]
+	if len(bom) > 0 && bomCharset(bom) == "" {
+		return nil, false
+	}
+	edited := append([]byte{}, bom...)
+	for _, p := range pieces {
+		var b []byte
+		if p.Edited {
+			b, err = encodeEdited(root, matchLineEndings(original, []byte(p.Text)))
+		} else {
+			b, err = encodeSource(info.Name, []byte(p.Text))
+		}
+		if err != nil {
+			return nil, false
+		}
+		edited = append(edited, b...)
+	}
+	// character references written for what the charset can't encode
+	// aren't decoded everywhere, e.g. in the text of a <script>
+	written, err := parseDecoded(bytes.NewReader(edited), pupCharset, "")
+	if err != nil {
+		return nil, false
+	}
+	defer releaseDocument(written)
+	got.Reset()
+	writeHTML(&got, written, htmlStyle{Exact: true}, false)
+	if expected.String() != got.String() {
+		return nil, false
+	}
+	return edited, true
+}
+
+// Encode part of a document's source in the charset it was decoded from
+func encodeSource(name string, content []byte) ([]byte, error) {
+	if name == "utf-8" {
+		return content, nil
+	}
+	e, _ := charset.Lookup(name)
+	return e.NewEncoder().Bytes(content)
+}
+
+// Write newlines as CRLF if most lines of the original end with one
+func matchLineEndings(original, s []byte) []byte {
+	crlf := bytes.Count(original, []byte("\r\n"))
+	if crlf == 0 || crlf*2 < bytes.Count(original, []byte("\n")) {
+		return s
+	}
+	s = bytes.Replace(s, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(s, []byte("\n"), []byte("\r\n"), -1)
+}
+
+// Encode serialized markup for a file. A document is written in the charset
+// it declared, but one which declared none is only known to be ASCII, so
+// other characters are written as character references. Those aren't
+// decoded in comments or the text of raw text elements such as <script>,
+// so characters there which can't be encoded are an error.
+func encodeEdited(root *html.Node, edited []byte) ([]byte, error) {
+	info := documentCharset(root)
+	var escape, encode func([]byte) ([]byte, error)
+	switch info.Source {
+	case "flag", "bom", "content-type", "meta", "declaration":
+		if info.Name == "utf-8" {
+			return edited, nil
+		}
+		// not charset.Lookup, whose encoders escape what they can't encode
+		e, _ := htmlindex.Get(info.Name)
+		escape = encoding.HTMLEscapeUnsupported(e.NewEncoder()).Bytes
+		encode = func(b []byte) ([]byte, error) {
+			encoded, err := e.NewEncoder().Bytes(b)
+			if err != nil {
+				return nil, fmt.Errorf("characters which can't be written in %s", info.Name)
+			}
+			return encoded, nil
+		}
+	case "sniffed":
+		// the document already had UTF-8 in it
+		return edited, nil
+	default:
+		escape = escapeNonASCII
+		encode = func(b []byte) ([]byte, error) {
+			for _, c := range b {
+				if c >= utf8.RuneSelf {
+					return nil, fmt.Errorf("non-ASCII characters, which can't be written without a declared charset")
+				}
+			}
+			return b, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(edited))
+	rawText := ""
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		var b []byte
+		var err error
+		switch {
+		case tt == html.CommentToken:
+			if b, err = encode(z.Raw()); err != nil {
+				return nil, fmt.Errorf("a comment has %s", err.Error())
+			}
+		case tt == html.TextToken && rawText != "":
+			if b, err = encode(z.Raw()); err != nil {
+				return nil, fmt.Errorf("<%s> has %s", rawText, err.Error())
+			}
+		default:
+			if b, err = escape(z.Raw()); err != nil {
+				return nil, err
+			}
+		}
+		buf.Write(b)
+		rawText = ""
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			name, _ := z.TagName()
+			n := &html.Node{Type: html.ElementNode, Data: string(name), DataAtom: atom.Lookup(name)}
+			if isRawTextElement(n) {
+				rawText = n.Data
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Write characters outside ASCII as character references
+func escapeNonASCII(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, r := range string(b) {
+		if r < utf8.RuneSelf {
+			buf.WriteRune(r)
+		} else {
+			fmt.Fprintf(&buf, "&#x%X;", r)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Atomically replace the content of a file
+func replaceFile(name string, content []byte, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".pup")
+	if err != nil {
+		return err
+	}
+	// once renamed there's nothing to remove
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
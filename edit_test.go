@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestApplyEdits(t *testing.T) {
+	tests := []struct {
+		flag, selector, arg string
+		input, expected     string
+		changed             int
+	}{
+		{"--delete", ".ads", "", `<div class="ads">x</div><p>a</p>`, `<p>a</p>`, 1},
+		{"--unwrap", "span", "", `<p><span>a <b>b</b></span></p>`, `<p>a <b>b</b></p>`, 1},
+		{"--set-attr", "a", "href=/new", `<a href="/old">a</a><a>b</a>`, `<a href="/new">a</a><a href="/new">b</a>`, 2},
+		{"--set-attr", "a", "href=/same", `<a href="/same">a</a>`, `<a href="/same">a</a>`, 0},
+		{"--remove-attr", "a", "onclick", `<a onclick="t()" href="/">a</a>`, `<a href="/">a</a>`, 1},
+		{"--replace", "td", "<td>1</td><td>2</td>", `<table><tr><td>x</td></tr></table>`,
+			`<table><tbody><tr><td>1</td><td>2</td></tr></tbody></table>`, 1},
+		{"--wrap", "img", `<figure><div></div><p></p></figure>`, `<img src="a.png">`,
+			`<figure><div><img src="a.png"></div><p></p></figure>`, 1},
+		{"--set-text", "h1", "a < b", `<h1>old <b>x</b></h1>`, `<h1>a &lt; b</h1>`, 1},
+	}
+	for _, test := range tests {
+		edit, err := parseEdit(test.flag, test.selector, test.arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := html.Parse(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed := applyEdits(root, []editAction{edit})
+		body := root.FirstChild.LastChild
+		var buf bytes.Buffer
+		for c := body.FirstChild; c != nil; c = c.NextSibling {
+			writeHTML(&buf, c, htmlStyle{Exact: true}, false)
+		}
+		if buf.String() != test.expected || changed != test.changed {
+			t.Errorf("%s %s: expected %d changes and\n%s\ngot %d and\n%s",
+				test.flag, test.selector, test.changed, test.expected, changed, buf.String())
+		}
+	}
+}
+
+func TestEncodeEdited(t *testing.T) {
+	tests := []struct {
+		info     charsetInfo
+		expected string
+	}{
+		{charsetInfo{Name: "windows-1252", Source: "fallback"}, "&#xA9; &#x2014; caf&#xE9;"},
+		{charsetInfo{Name: "windows-1252", Source: "meta"}, "\xa9 \x97 caf\xe9"},
+		{charsetInfo{Name: "iso-8859-2", Source: "flag"}, "&#169; &#8212; caf\xe9"},
+		{charsetInfo{Name: "utf-8", Source: "sniffed"}, "© — café"},
+	}
+	for _, test := range tests {
+		root := &html.Node{Type: html.DocumentNode}
+		setDocumentCharset(root, test.info)
+		edited, err := encodeEdited(root, []byte("© — café"))
+		releaseDocument(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(edited) != test.expected {
+			t.Errorf("%s from the %s: expected %q got %q", test.info.Name, test.info.Source, test.expected, edited)
+		}
+	}
+}
+
+// Character references aren't decoded in comments or raw text, so what
+// can't be encoded there isn't written as one
+func TestEncodeEditedRawText(t *testing.T) {
+	tests := []struct {
+		info     charsetInfo
+		input    string
+		expected string
+	}{
+		{charsetInfo{Name: "windows-1252", Source: "fallback"},
+			"<p title=\"\u00e9\">\u00e9</p><textarea>\u00e9</textarea>", "<p title=\"&#xE9;\">&#xE9;</p><textarea>&#xE9;</textarea>"},
+		{charsetInfo{Name: "windows-1252", Source: "fallback"}, "<script>a</script>\u00e9", "<script>a</script>&#xE9;"},
+		{charsetInfo{Name: "windows-1252", Source: "fallback"}, "<script>\u00e9</script>", ""},
+		{charsetInfo{Name: "windows-1252", Source: "fallback"}, "<!-- \u00e9 -->", ""},
+		{charsetInfo{Name: "iso-8859-2", Source: "flag"}, "<style>\u00e9</style>", "<style>\xe9</style>"},
+		{charsetInfo{Name: "iso-8859-2", Source: "flag"}, "<style>\u2014</style>", ""},
+	}
+	for _, test := range tests {
+		root := &html.Node{Type: html.DocumentNode}
+		setDocumentCharset(root, test.info)
+		edited, err := encodeEdited(root, []byte(test.input))
+		releaseDocument(root)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("%q in %s: expected an error, got %q", test.input, test.info.Name, edited)
+			}
+			continue
+		}
+		if err != nil || string(edited) != test.expected {
+			t.Errorf("%q in %s: expected %q got %q, %v", test.input, test.info.Name, test.expected, edited, err)
+		}
+	}
+}
+
+func TestEditInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(edits []editAction) { pupEdits = edits }(pupEdits)
+
+	page := "<!DOCTYPE html>\r\n<html>\r\n<head><title>t</title></head>\r\n<body>\r\n" +
+		"<p class=x>a<br/>b</p>\r\n<ul>\r\n  <li>one\r\n  <li class=two>two\r\n</ul>\r\n" +
+		"<div id=d><span>s</span></div>\r\n</body>\r\n</html>\r\n"
+	tests := []struct {
+		edits    [][]string
+		input    string
+		expected string
+	}{
+		// nothing is selected, so the file is left as it was
+		{[][]string{{"--delete", "table"}}, page, page},
+		{[][]string{{"--set-attr", "li.two", "title=T"}}, page,
+			strings.Replace(page, "<li class=two>", `<li class="two" title="T">`, 1)},
+		{[][]string{{"--remove-attr", "p", "class"}, {"--set-text", "span", "a < b"}}, page,
+			strings.Replace(strings.Replace(page, "<p class=x>", "<p>", 1), "<span>s</span>", "<span>a &lt; b</span>", 1)},
+		{[][]string{{"--delete", "li.two"}}, page, strings.Replace(page, "<li class=two>two\r\n", "", 1)},
+		{[][]string{{"--unwrap", "#d"}, {"--wrap", "p", "<section>\n</section>"}}, page,
+			strings.Replace(strings.Replace(page, "<div id=d><span>s</span></div>", "<span>s</span>", 1),
+				"<p class=x>a<br/>b</p>", "<section>\r\n<p class=x>a<br/>b</p></section>", 1)},
+		{[][]string{{"--replace", "li", "<li>x</li>"}}, page,
+			strings.Replace(page, "<li>one\r\n  <li class=two>two\r\n", "<li>x</li><li>x</li>", 1)},
+		// the wrapper wasn't in the source, so the document is written again
+		{[][]string{{"--wrap", "span", "<b></b>"}, {"--set-attr", "b", "id=b"}}, "<p class=x>\r\n<span>s</span></p>\r\n",
+			"<html><head></head><body><p class=\"x\">\r\n<b id=\"b\"><span>s</span></b></p>\r\n</body></html>\r\n"},
+		{[][]string{{"--set-text", "p.y", "caf\u00e9"}}, "<p>\xa9</p><p class=y>x</p>", "<p>\xa9</p><p class=y>caf&#xE9;</p>"},
+	}
+	for _, test := range tests {
+		pupEdits = nil
+		for _, args := range test.edits {
+			edit, err := parseEdit(args[0], args[1], strings.Join(args[2:], ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pupEdits = append(pupEdits, edit)
+		}
+		name := filepath.Join(dir, "a.html")
+		if err := ioutil.WriteFile(name, []byte(test.input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := editInPlace(ioutil.Discard, name); err != nil {
+			t.Fatal(err)
+		}
+		edited, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(edited) != test.expected {
+			t.Errorf("%v: expected\n%q\ngot\n%q", test.edits, test.expected, edited)
+		}
+	}
+
+	// there's no way to write the text of the script in ASCII
+	input := "<p>\xa9</p><script>x</script>"
+	name := filepath.Join(dir, "a.html")
+	if err := ioutil.WriteFile(name, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	edit, err := parseEdit("--set-text", "script", "caf\u00e9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pupEdits = []editAction{edit}
+	if err := editInPlace(ioutil.Discard, name); err == nil {
+		t.Errorf("expected an error writing non-ASCII text in a script")
+	}
+	if edited, err := ioutil.ReadFile(name); err != nil || string(edited) != input {
+		t.Errorf("expected the file unchanged, got %q, %v", edited, err)
+	}
+}
+
+// Documents are written back byte for byte when none of their source is
+// edited, whatever their charset and line endings
+func TestSpliceUnchanged(t *testing.T) {
+	page, err := ioutil.ReadFile("tests/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []string{
+		string(page),
+		strings.Replace(string(page), "\n", "\r\n", -1),
+		"<p class=x>a<br/>b\r\n<td>c",
+		"\xef\xbb\xbf<p>a</p>",
+		"<meta charset=iso-8859-2><p>\xa9 caf\xe9</p>",
+		"<p>\xa9 caf\xe9</p>",
+	}
+	for _, input := range inputs {
+		root, source, err := parseSource(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		edited, ok := spliceEdited(root, source, []byte(input))
+		releaseDocument(root)
+		if !ok || string(edited) != input {
+			t.Errorf("%.200q: expected it unchanged, got %v %.200q", input, ok, edited)
+		}
+	}
+}
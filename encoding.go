@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// How the character encoding of a document was chosen
+type charsetInfo struct {
+	// The encoding used, e.g. windows-1252
+	Name string
+	// Where it came from: flag, bom, content-type, meta, sniffed or fallback,
+	// or for XML the declaration or the default
+	Source string
+	// The charset declared by a meta tag, if any
+	Meta string
+	// Set if the meta tag conflicts with the encoding used or the content
+	Warning string
+}
+
+var (
+	charsetInfosMu sync.Mutex
+	charsetInfos   = map[*html.Node]charsetInfo{}
+)
+
+func setDocumentCharset(root *html.Node, info charsetInfo) {
+	charsetInfosMu.Lock()
+	defer charsetInfosMu.Unlock()
+	charsetInfos[root] = info
+}
+
+// How the encoding of a node's document was chosen
+func documentCharset(n *html.Node) charsetInfo {
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	charsetInfosMu.Lock()
+	defer charsetInfosMu.Unlock()
+	return charsetInfos[root]
+}
+
+var boms = []struct {
+	bom   []byte
+	label string
+}{
+	{[]byte{0xfe, 0xff}, "utf-16be"},
+	{[]byte{0xff, 0xfe}, "utf-16le"},
+	{[]byte{0xef, 0xbb, 0xbf}, "utf-8"},
+}
+
+// Determine the encoding of a document from the start of its content,
+// following https://html.spec.whatwg.org/#determining-the-character-encoding
+// cs is a charset given with --charset and declared is one given by the
+// Content-Type of the document, either may be empty.
+func determineCharset(preview []byte, cs, declared string) (encoding.Encoding, charsetInfo, error) {
+	info := charsetInfo{Meta: metaCharset(preview)}
+	var e encoding.Encoding
+	if cs != "" {
+		if e, info.Name = charset.Lookup(cs); e == nil {
+			return nil, info, fmt.Errorf("'%s' is not a valid charset", cs)
+		}
+		info.Source = "flag"
+	} else if label := bomCharset(preview); label != "" {
+		e, info.Name = charset.Lookup(label)
+		info.Source = "bom"
+	} else if e, info.Name = charset.Lookup(declared); e != nil {
+		info.Source = "content-type"
+	} else if e, info.Name = charset.Lookup(info.Meta); e != nil {
+		info.Source = "meta"
+	} else if looksUTF8(preview) {
+		e, info.Name = encoding.Nop, "utf-8"
+		info.Source = "sniffed"
+	} else {
+		e, info.Name = charset.Lookup(pupCharsetFallback)
+		info.Source = "fallback"
+	}
+	info.Warning = charsetConflict(preview, info)
+	return e, info, nil
+}
+
+func bomCharset(content []byte) string {
+	for _, b := range boms {
+		if bytes.HasPrefix(content, b.bom) {
+			return b.label
+		}
+	}
+	return ""
+}
+
+var metaContentCharsetRe = regexp.MustCompile(`(?i)charset\s*=\s*["']?([^"';\s]+)`)
+
+// Find the charset declared by a meta tag, either
+// <meta charset="..."> or <meta http-equiv="Content-Type" content="...">
+func metaCharset(content []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, hasAttr := z.TagName()
+			if string(tag) != "meta" {
+				continue
+			}
+			var label, content string
+			var isContentType bool
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				switch string(key) {
+				case "charset":
+					label = string(val)
+				case "http-equiv":
+					isContentType = strings.EqualFold(string(val), "content-type")
+				case "content":
+					content = string(val)
+				}
+			}
+			if label == "" && isContentType {
+				if match := metaContentCharsetRe.FindStringSubmatch(content); match != nil {
+					label = match[1]
+				}
+			}
+			if label = strings.TrimSpace(label); label != "" {
+				// a page can't declare itself UTF-16 in bytes read as ASCII
+				if _, name := charset.Lookup(label); strings.HasPrefix(name, "utf-16") {
+					return "utf-8"
+				}
+				return label
+			}
+		}
+	}
+}
+
+// Is the content valid UTF-8, ignoring a partial rune at the end?
+func validUTF8(content []byte) bool {
+	for i := len(content) - 1; i >= 0 && i > len(content)-4; i-- {
+		b := content[i]
+		if b < 0x80 {
+			break
+		}
+		if utf8.RuneStart(b) {
+			content = content[:i]
+			break
+		}
+	}
+	return utf8.Valid(content)
+}
+
+// Does the content hold multi-byte UTF-8 sequences and nothing invalid?
+func looksUTF8(content []byte) bool {
+	for _, c := range content {
+		if c >= 0x80 {
+			return validUTF8(content)
+		}
+	}
+	return false
+}
+
+// Describe how the charset declared by a meta tag conflicts with the encoding
+// used or the bytes seen, or return an empty string if it doesn't
+func charsetConflict(preview []byte, info charsetInfo) string {
+	if info.Meta == "" {
+		return ""
+	}
+	_, meta := charset.Lookup(info.Meta)
+	switch {
+	case meta == "":
+		return fmt.Sprintf("<meta> declares unknown charset '%s'", info.Meta)
+	case meta != info.Name:
+		return fmt.Sprintf("<meta> declares %s but %s from the %s was used", meta, info.Name, info.Source)
+	case meta == "utf-8" && !validUTF8(preview):
+		return "<meta> declares utf-8 but the content isn't valid UTF-8"
+	case meta != "utf-8" && looksUTF8(preview):
+		return fmt.Sprintf("<meta> declares %s but the content looks like UTF-8", meta)
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+type charsetTest struct {
+	content  string
+	cs       string
+	declared string
+	name     string
+	source   string
+	warns    bool
+}
+
+var charsetTests = []charsetTest{
+	charsetTest{"\xef\xbb\xbf<p>x</p>", "", "", "utf-8", "bom", false},
+	charsetTest{"<p>x</p>", "iso-8859-2", "", "iso-8859-2", "flag", false},
+	charsetTest{"<p>x</p>", "", "latin1", "windows-1252", "content-type", false},
+	charsetTest{"<meta charset=\"utf-8\"><p>caf\xc3\xa9</p>", "", "", "utf-8", "meta", false},
+	charsetTest{"<meta http-equiv=\"Content-Type\" content=\"text/html; charset=koi8-r\">", "", "", "koi8-r", "meta", false},
+	charsetTest{"<p>caf\xc3\xa9</p>", "", "", "utf-8", "sniffed", false},
+	charsetTest{"<p>caf\xe9</p>", "", "", "windows-1252", "fallback", false},
+	charsetTest{"<meta charset=\"utf-8\"><p>caf\xe9</p>", "", "", "utf-8", "meta", true},
+	charsetTest{"<meta charset=\"latin1\"><p>caf\xc3\xa9</p>", "", "", "windows-1252", "meta", true},
+	charsetTest{"\xef\xbb\xbf<meta charset=\"latin1\">", "", "", "utf-8", "bom", true},
+}
+
+func TestDetermineCharset(t *testing.T) {
+	for _, test := range charsetTests {
+		_, info, err := determineCharset([]byte(test.content), test.cs, test.declared)
+		if err != nil {
+			t.Errorf("%q: %s", test.content, err)
+			continue
+		}
+		if info.Name != test.name || info.Source != test.source || (info.Warning != "") != test.warns {
+			t.Errorf("%q: expected %s from %s (warning %v) got %+v",
+				test.content, test.name, test.source, test.warns, info)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Print the selected forms, or the forms within the selected nodes, as JSON.
+// With URLEncoded set, print the application/x-www-form-urlencoded body a
+// browser would submit for each form instead.
+type FormsDisplayer struct {
+	URLEncoded bool
+}
+
+type formInfo struct {
+	ID      string      `json:"id,omitempty"`
+	Name    string      `json:"name,omitempty"`
+	Method  string      `json:"method"`
+	Action  string      `json:"action"`
+	Enctype string      `json:"enctype"`
+	Fields  []formField `json:"fields"`
+	Data    []formEntry `json:"data"`
+	docSource
+}
+
+// A control associated with a form
+type formField struct {
+	Element  string       `json:"element"`
+	Type     string       `json:"type,omitempty"`
+	Name     string       `json:"name,omitempty"`
+	Value    string       `json:"value"`
+	Checked  bool         `json:"checked,omitempty"`
+	Disabled bool         `json:"disabled,omitempty"`
+	Multiple bool         `json:"multiple,omitempty"`
+	Options  []formOption `json:"options,omitempty"`
+}
+
+type formOption struct {
+	Value    string `json:"value"`
+	Text     string `json:"text"`
+	Selected bool   `json:"selected,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// A name and value the form would submit
+type formEntry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (f FormsDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	forms := []formInfo{}
+	for _, node := range nodes {
+		for _, form := range findForms(node) {
+			info := inspectForm(form)
+			info.docSource = documentSource(form)
+			forms = append(forms, info)
+		}
+	}
+	if f.URLEncoded {
+		for _, form := range forms {
+			fmt.Fprintln(w, urlEncodeEntries(form.Data))
+		}
+		return
+	}
+	data, err := json.MarshalIndent(forms, "", pupIndentString)
+	if err != nil {
+		panic("Could not jsonify forms")
+	}
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// Find a form, or the forms below a node
+func findForms(n *html.Node) []*html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Form {
+		return []*html.Node{n}
+	}
+	forms := []*html.Node{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		forms = append(forms, findForms(c)...)
+	}
+	return forms
+}
+
+func inspectForm(form *html.Node) formInfo {
+	info := formInfo{
+		Method:  "get",
+		Enctype: "application/x-www-form-urlencoded",
+		Fields:  []formField{},
+		Data:    []formEntry{},
+	}
+	info.ID, _ = getAttr(form, "id")
+	info.Name, _ = getAttr(form, "name")
+	if method, _ := getAttr(form, "method"); strings.EqualFold(method, "post") || strings.EqualFold(method, "dialog") {
+		info.Method = strings.ToLower(method)
+	}
+	action, _ := getAttr(form, "action")
+	info.Action = resolveURL(form, strings.TrimSpace(action))
+	switch enctype, _ := getAttr(form, "enctype"); strings.ToLower(enctype) {
+	case "multipart/form-data", "text/plain":
+		info.Enctype = strings.ToLower(enctype)
+	}
+
+	controls := formControls(form)
+	// only the last checked radio button of a group stays checked
+	checkedRadios := map[string]int{}
+	for i, control := range controls {
+		field := inspectControl(control)
+		if field.Type == "radio" && field.Checked && field.Name != "" {
+			if j, ok := checkedRadios[field.Name]; ok {
+				info.Fields[j].Checked = false
+			}
+			checkedRadios[field.Name] = i
+		}
+		info.Fields = append(info.Fields, field)
+	}
+	for i, control := range controls {
+		info.Data = append(info.Data, submittedEntries(control, info.Fields[i])...)
+	}
+	return info
+}
+
+// Find the controls owned by a form in document order. Controls elsewhere in
+// the document belong to it through their form attribute.
+func formControls(form *html.Node) []*html.Node {
+	root := form
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	formID, _ := getAttr(form, "id")
+	controls := []*html.Node{}
+	var walk func(n *html.Node, owner *html.Node)
+	walk = func(n *html.Node, owner *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Form:
+				walk(c, c)
+				continue
+			case atom.Input, atom.Select, atom.Textarea, atom.Button:
+				if id, ok := getAttr(c, "form"); ok {
+					if formID != "" && id == formID {
+						controls = append(controls, c)
+					}
+				} else if owner == form {
+					controls = append(controls, c)
+				}
+			}
+			walk(c, owner)
+		}
+	}
+	walk(root, nil)
+	return controls
+}
+
+func inspectControl(n *html.Node) formField {
+	field := formField{Element: n.Data, Disabled: isDisabledControl(n)}
+	field.Name, _ = getAttr(n, "name")
+	_, field.Multiple = getAttr(n, "multiple")
+	switch n.DataAtom {
+	case atom.Input:
+		field.Type = inputType(n)
+		field.Value, _ = getAttr(n, "value")
+		if field.Type == "checkbox" || field.Type == "radio" {
+			_, field.Checked = getAttr(n, "checked")
+			if _, ok := getAttr(n, "value"); !ok {
+				field.Value = "on"
+			}
+		}
+	case atom.Button:
+		field.Type = "submit"
+		if typ, ok := getAttr(n, "type"); ok {
+			field.Type = strings.ToLower(typ)
+		}
+		field.Value, _ = getAttr(n, "value")
+	case atom.Textarea:
+		var buf bytes.Buffer
+		writeRawText(&buf, n)
+		field.Value = buf.String()
+	case atom.Select:
+		field.Options = selectOptions(n)
+		for _, option := range field.Options {
+			if option.Selected {
+				field.Value = option.Value
+				break
+			}
+		}
+	}
+	return field
+}
+
+// The options of a select, with the ones a browser would select by default
+// marked
+func selectOptions(n *html.Node) []formOption {
+	options := []formOption{}
+	var walk func(n *html.Node, disabled bool)
+	walk = func(n *html.Node, disabled bool) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Option:
+				option := formOption{Text: nodeText(c)}
+				_, option.Selected = getAttr(c, "selected")
+				_, option.Disabled = getAttr(c, "disabled")
+				option.Disabled = option.Disabled || disabled
+				var ok bool
+				if option.Value, ok = getAttr(c, "value"); !ok {
+					option.Value = option.Text
+				}
+				options = append(options, option)
+			case atom.Optgroup:
+				_, groupDisabled := getAttr(c, "disabled")
+				walk(c, groupDisabled)
+			}
+		}
+	}
+	walk(n, false)
+
+	_, multiple := getAttr(n, "multiple")
+	selected := 0
+	for i := range options {
+		if options[i].Selected {
+			// only the last selected option counts without multiple
+			if !multiple && selected > 0 {
+				for j := 0; j < i; j++ {
+					options[j].Selected = false
+				}
+			}
+			selected++
+		}
+	}
+	// a drop down always has an option selected
+	size, _ := getAttr(n, "size")
+	if selected == 0 && !multiple && (size == "" || size == "0" || size == "1") {
+		for i := range options {
+			if !options[i].Disabled {
+				options[i].Selected = true
+				break
+			}
+		}
+	}
+	return options
+}
+
+// The entries a control contributes when its form is submitted without a
+// submit button
+func submittedEntries(n *html.Node, field formField) []formEntry {
+	if field.Disabled || field.Name == "" {
+		return nil
+	}
+	switch n.DataAtom {
+	case atom.Button:
+		return nil
+	case atom.Input:
+		switch field.Type {
+		case "submit", "reset", "button", "image":
+			return nil
+		case "checkbox", "radio":
+			if !field.Checked {
+				return nil
+			}
+		case "file":
+			return []formEntry{{Name: field.Name, Value: ""}}
+		}
+	case atom.Select:
+		entries := []formEntry{}
+		for _, option := range field.Options {
+			if option.Selected && !option.Disabled {
+				entries = append(entries, formEntry{Name: field.Name, Value: option.Value})
+			}
+		}
+		return entries
+	case atom.Textarea:
+		value := strings.Replace(field.Value, "\r\n", "\n", -1)
+		value = strings.Replace(value, "\n", "\r\n", -1)
+		return []formEntry{{Name: field.Name, Value: value}}
+	}
+	return []formEntry{{Name: field.Name, Value: field.Value}}
+}
+
+// The type of an input, defaulting to text
+func inputType(n *html.Node) string {
+	typ, _ := getAttr(n, "type")
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	if typ == "" {
+		return "text"
+	}
+	return typ
+}
+
+// Is a control disabled, either itself or by a fieldset it's in? Controls
+// in the first legend of a disabled fieldset aren't disabled by it.
+func isDisabledControl(n *html.Node) bool {
+	if _, ok := getAttr(n, "disabled"); ok {
+		return true
+	}
+	var child *html.Node
+	for p := n; p != nil; child, p = p, p.Parent {
+		if p.DataAtom != atom.Fieldset || p == n {
+			continue
+		}
+		if _, ok := getAttr(p, "disabled"); !ok {
+			continue
+		}
+		if child.DataAtom == atom.Legend && child == firstLegend(p) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func firstLegend(fieldset *html.Node) *html.Node {
+	for c := fieldset.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Legend {
+			return c
+		}
+	}
+	return nil
+}
+
+// Encode entries as application/x-www-form-urlencoded, keeping their order
+func urlEncodeEntries(entries []formEntry) string {
+	pairs := []string{}
+	for _, entry := range entries {
+		pairs = append(pairs, url.QueryEscape(entry.Name)+"="+url.QueryEscape(entry.Value))
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFormsURLEncoded(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		// inputs
+		{`<form><input name=q value="a b&c"><input name=empty></form>`, `q=a+b%26c&empty=`},
+		{`<form><input value=x><input name=a type=submit value=go><input name=b type=image></form>`, ``},
+		{`<form><input name=f type=file value=x><input name=r type=reset><button name=b>x</button></form>`, `f=`},
+		{`<form><input name=c type=checkbox><input name=c type=checkbox checked><input name=d type=CHECKBOX checked value=1></form>`, `c=on&d=1`},
+
+		// radio groups submit the checked button, or nothing
+		{`<form><input type=radio name=r value=a><input type=radio name=r value=b checked><input type=radio name=r value=c></form>`, `r=b`},
+		{`<form><input type=radio name=r value=a><input type=radio name=r value=b></form>`, ``},
+		{`<form><input type=radio name=r value=a checked><input type=radio name=s value=b checked></form>`, `r=a&s=b`},
+		{`<form><input type=radio name=r value=a checked><input type=radio name=r value=b checked></form>`, `r=b`},
+		{`<form id=f><input type=radio name=r value=a checked></form><input type=radio name=r value=b checked form=f><form><input type=radio name=r value=c checked></form>`, "r=b\nr=c"},
+
+		// selects
+		{`<form><select name=s><option>a<option>b</select></form>`, `s=a`},
+		{`<form><select name=s><option disabled>a<option value=2>b</select></form>`, `s=2`},
+		{`<form><select name=s><option selected>a<option selected>b</select></form>`, `s=b`},
+		{`<form><select name=s multiple><option selected>a<option>b<option selected>c</select></form>`, `s=a&s=c`},
+		{`<form><select name=s multiple><option>a</select></form>`, ``},
+		{`<form><select name=s size=3><option>a<option>b</select></form>`, ``},
+		{`<form><select name=s><optgroup disabled><option>a</optgroup><option>b</select></form>`, `s=b`},
+		{`<form><select name=s><option selected disabled>a<option>b</select></form>`, ``},
+
+		// disabled controls and fieldsets
+		{`<form><input name=a disabled><input name=b></form>`, `b=`},
+		{`<form><fieldset disabled><input name=a><div><input name=b></div></fieldset><input name=c></form>`, `c=`},
+		{`<form><fieldset disabled><legend><input name=a></legend><legend><input name=b></legend></fieldset></form>`, `a=`},
+		{`<form><fieldset disabled><fieldset><legend><input name=a></legend></fieldset></fieldset></form>`, ``},
+		{`<form><fieldset><legend><input name=a></legend><input name=b></fieldset></form>`, `a=&b=`},
+
+		// the form attribute
+		{`<form id=f><input name=a></form><input name=b form=f><input name=c>`, `a=&b=`},
+		{`<form id=f><input name=a form=g></form><form id=g></form>`, "\na="},
+		{`<form><input name=a form=""></form>`, ``},
+		{`<input name=b form=f><form id=f><input name=a></form>`, `b=&a=`},
+
+		// textareas submit CRLF line endings
+		{"<form><textarea name=t>\na\nb\r\nc</textarea></form>", `t=a%0D%0Ab%0D%0Ac`},
+
+		// each form is printed on its own line
+		{`<form><input name=a></form><form><input name=b></form>`, "a=\nb="},
+	}
+	for _, test := range tests {
+		output := displayString(t, FormsDisplayer{URLEncoded: true}, test.input, "html")
+		if output != test.expected+"\n" {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestFormsJSON(t *testing.T) {
+	input := `<form id=login method=POST action=" /login " enctype=TEXT/PLAIN>
+		<input name=user value=me>
+		<select name=lang><option value=en>English<option value=fr selected>French</select>
+		<button>Log in</button>
+	</form>
+	<form method=put enctype=application/json></form>`
+	var forms []formInfo
+	if err := json.Unmarshal([]byte(displayString(t, FormsDisplayer{}, input, "html")), &forms); err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) != 2 {
+		t.Fatalf("expected 2 forms, got %d", len(forms))
+	}
+	form := forms[0]
+	if form.ID != "login" || form.Method != "post" || form.Action != "/login" || form.Enctype != "text/plain" {
+		t.Errorf("unexpected form %+v", form)
+	}
+	if len(form.Fields) != 3 {
+		t.Fatalf("expected 3 fields, got %+v", form.Fields)
+	}
+	if f := form.Fields[0]; f.Element != "input" || f.Type != "text" || f.Name != "user" || f.Value != "me" {
+		t.Errorf("unexpected field %+v", f)
+	}
+	if f := form.Fields[1]; f.Element != "select" || f.Value != "fr" || len(f.Options) != 2 ||
+		f.Options[0] != (formOption{Value: "en", Text: "English"}) ||
+		f.Options[1] != (formOption{Value: "fr", Text: "French", Selected: true}) {
+		t.Errorf("unexpected field %+v", f)
+	}
+	if f := form.Fields[2]; f.Element != "button" || f.Type != "submit" {
+		t.Errorf("unexpected field %+v", f)
+	}
+	if len(form.Data) != 2 || form.Data[0] != (formEntry{"user", "me"}) || form.Data[1] != (formEntry{"lang", "fr"}) {
+		t.Errorf("unexpected data %+v", form.Data)
+	}
+	// unknown methods and encodings fall back to the defaults
+	if form := forms[1]; form.Method != "get" || form.Enctype != "application/x-www-form-urlencoded" {
+		t.Errorf("unexpected form %+v", form)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var fragmentContextRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`)
+
+// Parse the value of --fragment=context. The context defaults to body.
+func parseFragmentContext(context string) (string, error) {
+	if context == "" {
+		return "body", nil
+	}
+	if !fragmentContextRe.MatchString(context) {
+		return "", fmt.Errorf("Invalid fragment context '%s'", context)
+	}
+	return strings.ToLower(context), nil
+}
+
+// Parse html as a fragment within a context element, e.g. <tr> rows within
+// a <tbody>. The parsed nodes are held by a document node rather than the
+// <html>, <head> and <body> elements the parser would otherwise add.
+func parseFragment(r io.Reader, context string) (*html.Node, error) {
+	nodes, err := html.ParseFragment(r, &html.Node{
+		Type:     html.ElementNode,
+		Data:     context,
+		DataAtom: atom.Lookup([]byte(context)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	root := &html.Node{Type: html.DocumentNode}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	return root, nil
+}
+
+// Replace the document node of a fragment with the nodes it holds, so a
+// query without selectors displays the fragment as it was parsed
+func fragmentNodes(nodes []*html.Node) []*html.Node {
+	expanded := []*html.Node{}
+	for _, n := range nodes {
+		if n.Type != html.DocumentNode {
+			expanded = append(expanded, n)
+			continue
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			expanded = append(expanded, c)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseFragment(t *testing.T) {
+	root, err := parseFragment(strings.NewReader("<tr><td>a</td></tr><tr><td>b</td></tr>"), "tbody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := fragmentNodes([]*html.Node{root})
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes got %d", len(nodes))
+	}
+	for _, n := range nodes {
+		if n.Data != "tr" {
+			t.Errorf("expected <tr> got <%s>", n.Data)
+		}
+	}
+
+	// without a table context the rows are dropped, as in a document
+	root, err = parseFragment(strings.NewReader("<tr><td>a</td></tr>"), "body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := root.FirstChild; n == nil || n.Type != html.TextNode || n.Data != "a" {
+		t.Errorf("expected the text of the row got %v", n)
+	}
+}
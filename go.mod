@@ -3,7 +3,9 @@ module github.com/ericchiang/pup
 go 1.13
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/fatih/color v1.0.0
+	github.com/klauspost/compress v1.13.6
 	github.com/mattn/go-colorable v0.0.5
 	github.com/mattn/go-isatty v0.0.0-20151211000621-56b76bdf51f7 // indirect
 	golang.org/x/net v0.0.0-20160720084139-4d38db76854b
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// The name used for standard input in output, as grep does
+const stdinName = "(standard input)"
+
+// File extensions read when recursing into a directory
+var htmlExts = map[string]bool{
+	".htm":   true,
+	".html":  true,
+	".mht":   true,
+	".mhtml": true,
+	".shtml": true,
+	".xhtml": true,
+}
+
+// File extensions of XML documents, which are taken for files after the
+// selectors with --xml
+var xmlExts = map[string]bool{
+	".atom": true,
+	".rss":  true,
+	".svg":  true,
+	".xml":  true,
+}
+
+// Expand a -f argument. Globs expand to the files they match in lexical
+// order; a pattern matching nothing is kept so opening it reports an error.
+func expandGlob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid file pattern '%s': %s", pattern, err.Error())
+	}
+	if len(matches) == 0 {
+		return []string{pattern}, nil
+	}
+	return matches, nil
+}
+
+// Find the HTML files below a directory in lexical order, including
+// compressed ones
+func walkInputs(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && htmlExts[inputExt(path)] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// The extension of a file, ignoring the extension of its compression, e.g.
+// .html for page.html.gz
+func inputExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if compressionExts[ext] != "" {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name))))
+	}
+	return ext
+}
+
+// Characters of selectors and display functions, such as the / of
+// a[href="/"], which files named after the selectors aren't taken to have
+const selectorChars = `[](){}"'`
+
+// Pop the trailing arguments which name files. Selectors never follow a
+// file, so the first argument which isn't a file ends the search. As a
+// selector such as 'a' or 'table' can name a file too, only arguments with a
+// directory or an HTML extension (or an XML one with --xml) are taken for
+// files; other files are given after -- or with -f. A file which can't be
+// found is an error, as it is with -f.
+func trailingInputs(args []string) (rest, files []string, err error) {
+	i := len(args)
+	for i > 0 {
+		name := args[i-1]
+		ext := inputExt(name)
+		if strings.ContainsAny(name, selectorChars) ||
+			!strings.ContainsRune(name, '/') && !strings.ContainsRune(name, filepath.Separator) &&
+				!htmlExts[ext] && !(pupXML && xmlExts[ext]) {
+			break
+		}
+		if _, err := os.Stat(name); err != nil {
+			return nil, nil, err
+		}
+		i--
+	}
+	return args[:i], args[i:], nil
+}
+
+// Open an input, which is standard input if name is empty
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "" {
+		return os.Stdin, nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s: is a directory (use -r to search it)", name)
+	}
+	return os.Open(name)
+}
+
+// The name of an input as printed in output
+func inputName(name string) string {
+	if name == "" {
+		return stdinName
+	}
+	return name
+}
+
+// Where a document came from. Results are attributed to it with a line
+// prefix, or with these fields in JSON output.
+type docSource struct {
+	File string `json:"file,omitempty"`
+	URL  string `json:"page_url,omitempty"`
+	Date string `json:"date,omitempty"`
+}
+
+// The name of a source as listed by --files-with-matches
+func (s docSource) name() string {
+	if s.URL != "" {
+		return s.URL
+	}
+	return s.File
+}
+
+// The prefix for each line of a document's results
+func (s docSource) prefix() string {
+	if s.URL != "" {
+		return s.URL + "\t" + s.Date + "\t"
+	}
+	return s.File + ":"
+}
+
+// Add the fields of a source to a jsonified node
+func (s docSource) addTo(vals map[string]interface{}) {
+	for key, val := range map[string]string{"file": s.File, "page_url": s.URL, "date": s.Date} {
+		if val != "" {
+			vals[key] = val
+		}
+	}
+}
+
+var (
+	docSourcesMu sync.Mutex
+	docSources   = map[*html.Node]docSource{}
+)
+
+// Record where a document came from
+func setDocumentSource(root *html.Node, src docSource) {
+	docSourcesMu.Lock()
+	defer docSourcesMu.Unlock()
+	docSources[root] = src
+}
+
+// Forget the side tables of a document once it has been displayed
+func releaseDocument(root *html.Node) {
+	docSourcesMu.Lock()
+	delete(docSources, root)
+	docSourcesMu.Unlock()
+	baseCacheMu.Lock()
+	delete(baseCache, root)
+	baseCacheMu.Unlock()
+	mhtmlArchivesMu.Lock()
+	delete(mhtmlArchives, root)
+	mhtmlArchivesMu.Unlock()
+	charsetInfosMu.Lock()
+	delete(charsetInfos, root)
+	charsetInfosMu.Unlock()
+	xmlDocumentsMu.Lock()
+	delete(xmlDocuments, root)
+	xmlDocumentsMu.Unlock()
+	nodePositionsMu.Lock()
+	delete(nodePositions, root)
+	nodePositionsMu.Unlock()
+}
+
+func lookupSource(n *html.Node) docSource {
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	docSourcesMu.Lock()
+	defer docSourcesMu.Unlock()
+	return docSources[root]
+}
+
+// Where a node's document came from when results are attributed to their
+// source, or an empty source otherwise
+func documentSource(n *html.Node) docSource {
+	if !pupWithFilename {
+		return docSource{}
+	}
+	return lookupSource(n)
+}
+
+// Does a displayer print a JSON document? Those carry the fields of a docSource
+// instead of having their lines prefixed.
+func isJSONDisplayer(d Displayer) bool {
+	switch d := d.(type) {
+	case JSONDisplayer, FormsDisplayer, SchemaDisplayer:
+		return true
+	case LinksDisplayer:
+		return d.JSON
+	}
+	return false
+}
+
+// A writer which starts every line with a prefix, e.g. "index.html:"
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		if !p.midLine {
+			if _, err := p.w.Write(p.prefix); err != nil {
+				return n, err
+			}
+		}
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+		}
+		p.midLine = line[len(line)-1] != '\n'
+		m, err := p.w.Write(line)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		b = b[len(line):]
+	}
+	return n, nil
+}
+
+// Parse an input, select nodes from it and display them. The input is
+// streamed if stream is set, or linted with --lint.
+func queryInput(w io.Writer, name string, selectorFuncs []SelectorFunc, stream *streamSelector) error {
+	r, err := openInput(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	src := docSource{File: inputName(name)}
+	if pupLint != "" {
+		err = lintInput(w, r, src)
+	} else if stream != nil {
+		err = streamHTML(w, r, src, stream, selectorFuncs)
+	} else {
+		var root *html.Node
+		if root, err = ParseHTML(r, pupCharset); err == nil {
+			err = queryDocument(w, root, src, selectorFuncs)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", inputName(name), err.Error())
+	}
+	return nil
+}
+
+// Select nodes from a parsed document and display them. An error displaying
+// them, such as a template which fails, is returned to fail the job.
+func queryDocument(w io.Writer, root *html.Node, src docSource, selectorFuncs []SelectorFunc) error {
+	startDocument(root, src)
+	defer releaseDocument(root)
+	applyEdits(root, pupEdits)
+	if pupSanitize != nil {
+		pupSanitize.sanitize(root)
+	}
+	nodes := SelectNodes(root, selectorFuncs)
+	if pupFragment != "" {
+		nodes = fragmentNodes(nodes)
+	}
+	if pupFilesWithMatches {
+		if len(nodes) > 0 {
+			fmt.Fprintln(w, src.name())
+		}
+		return nil
+	}
+	w = resultWriter(w, src)
+	if pupShowCharset {
+		info := documentCharset(root)
+		fmt.Fprintf(w, "%s\t%s\n", info.Name, info.Source)
+		return nil
+	}
+	return display(pupDisplayer, w, nodes)
+}
+
+// Record where a document came from and warn about its charset. The
+// document must be released once it has been displayed.
+func startDocument(root *html.Node, src docSource) {
+	setDocumentSource(root, src)
+	if info := documentCharset(root); info.Warning != "" {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", src.name(), info.Warning)
+	}
+}
+
+// The writer for the results of a document. Results are prefixed with their
+// source when they're attributed and aren't JSON, or positions which name
+// the file already.
+func resultWriter(w io.Writer, src docSource) io.Writer {
+	_, loc := pupDisplayer.(LocDisplayer)
+	if pupWithFilename && (pupShowCharset || !isJSONDisplayer(pupDisplayer) && !loc) {
+		return &prefixWriter{w: w, prefix: []byte(src.prefix())}
+	}
+	return w
+}
+
+// Write the results of one document
+type queryJob func(w io.Writer) error
+
+// Where a job writes its results. They're kept until the jobs before it
+// have finished, then written and anything after written straight through,
+// so results are printed in the order of the jobs as soon as they can be.
+type jobOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	w   io.Writer
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.w != nil {
+		return o.w.Write(p)
+	}
+	return o.buf.Write(p)
+}
+
+// Write what the job has written so far to w, and anything after it
+func (o *jobOutput) start(w io.Writer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	w.Write(o.buf.Bytes())
+	o.buf = bytes.Buffer{}
+	o.w = w
+}
+
+// A job which has been started, and its error once it's finished
+type pendingJob struct {
+	out  *jobOutput
+	done chan error
+}
+
+// Queue a job for each input file and WARC record, reading stdin if no
+// files were given. A WARC file which can't be read becomes a failed job.
+// Files are streamed if stream is set.
+func queryJobs(selectorFuncs []SelectorFunc, stream *streamSelector) <-chan queryJob {
+	inputs := pupInputs
+	if len(inputs) == 0 && len(pupWarcs) == 0 {
+		inputs = []string{""}
+	}
+	jobs := make(chan queryJob)
+	go func() {
+		defer close(jobs)
+		for _, name := range inputs {
+			name := name
+			jobs <- func(w io.Writer) error {
+				if pupInPlace {
+					return editInPlace(w, name)
+				}
+				return queryInput(w, name, selectorFuncs, stream)
+			}
+		}
+		for _, name := range pupWarcs {
+			if err := queueWarc(jobs, name, selectorFuncs); err != nil {
+				jobs <- func(w io.Writer) error {
+					return fmt.Errorf("%s: %s", name, err.Error())
+				}
+			}
+		}
+	}()
+	return jobs
+}
+
+// Run jobs with a pool of workers, writing their results to w. Results are
+// written in the order of the jobs, the first unfinished job's as it writes
+// them, and at most n jobs are running or waiting to be written at once. The
+// error of each job is sent once its results have been written.
+func runJobs(jobs <-chan queryJob, n int, w io.Writer) <-chan error {
+	if n < 1 {
+		n = 1
+	}
+	pending := make(chan pendingJob, n-1)
+	go func() {
+		for job := range jobs {
+			p := pendingJob{out: &jobOutput{}, done: make(chan error, 1)}
+			pending <- p
+			go func(job queryJob) {
+				p.done <- job(p.out)
+			}(job)
+		}
+		close(pending)
+	}()
+	errs := make(chan error)
+	go func() {
+		for p := range pending {
+			p.out.start(w)
+			errs <- <-p.done
+		}
+		close(errs)
+	}()
+	return errs
+}
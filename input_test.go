@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &prefixWriter{w: &buf, prefix: []byte("a.html:")}
+	for _, s := range []string{"one\ntw", "o\n", "", "three\nfour\n"} {
+		w.Write([]byte(s))
+	}
+	expected := "a.html:one\na.html:two\na.html:three\na.html:four\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q got %q", expected, buf.String())
+	}
+}
+
+func TestTrailingInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "table", "b.html", "c.html.gz", "feed.xml"} {
+		if err := ioutil.WriteFile(name, []byte("<a href=x>hi</a>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		args, rest, files []string
+		err               bool
+	}{
+		// selectors which name files in the current directory
+		{[]string{"a"}, []string{"a"}, []string{}, false},
+		{[]string{"table", "a"}, []string{"table", "a"}, []string{}, false},
+		{[]string{"a", "b.html"}, []string{"a"}, []string{"b.html"}, false},
+		{[]string{"a", "b.html", "c.html.gz"}, []string{"a"}, []string{"b.html", "c.html.gz"}, false},
+		{[]string{"a", "./a", filepath.Join(dir, "table")}, []string{"a"}, []string{"./a", filepath.Join(dir, "table")}, false},
+		{[]string{"a", "feed.xml"}, []string{"a", "feed.xml"}, []string{}, false},
+		{[]string{`a[href="/b.html"]`}, []string{`a[href="/b.html"]`}, []string{}, false},
+		{[]string{"a", "tmpl{{{ attr \"href\" }}/}"}, []string{"a", "tmpl{{{ attr \"href\" }}/}"}, []string{}, false},
+
+		// files which look like files but can't be found
+		{[]string{"a", "missing.html"}, nil, nil, true},
+		{[]string{"a", "missing/a", "b.html"}, nil, nil, true},
+	}
+	for _, test := range tests {
+		rest, files, err := trailingInputs(test.args)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.args)
+			}
+			continue
+		}
+		if err != nil || !sliceEq(rest, test.rest) || !sliceEq(files, test.files) {
+			t.Errorf("%v: expected %v and files %v, got %v and %v", test.args, test.rest, test.files, rest, files)
+		}
+	}
+
+	// files after -- are read whatever they're named
+	defer func(inputs []string) { pupInputs = inputs }(pupInputs)
+	pupInputs = nil
+	rest, err := ProcessFlags([]string{"a", "--", "a", "--"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sliceEq(rest, []string{"a"}) || !sliceEq(pupInputs, []string{"a", "--"}) {
+		t.Errorf("a -- a --: expected [a] and files [a --], got %v and %v", rest, pupInputs)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Print every URL referenced by the selected nodes and their children. Each
+// link is printed tab separated as kind, element, URL and rel, or as JSON.
+type LinksDisplayer struct {
+	JSON bool
+}
+
+// A URL referenced by an element
+type Link struct {
+	URL      string   `json:"url"`
+	Kind     string   `json:"kind"`
+	Element  string   `json:"element"`
+	Attr     string   `json:"attr"`
+	Rel      []string `json:"rel,omitempty"`
+	Nofollow bool     `json:"nofollow,omitempty"`
+	docSource
+}
+
+func (l LinksDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	links := []Link{}
+	for _, node := range nodes {
+		for _, link := range findLinks(node) {
+			link.docSource = documentSource(node)
+			links = append(links, link)
+		}
+	}
+	if l.JSON {
+		data, err := json.MarshalIndent(links, "", pupIndentString)
+		if err != nil {
+			panic("Could not jsonify links")
+		}
+		fmt.Fprintf(w, "%s\n", data)
+		return
+	}
+	for _, link := range links {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", link.Kind, link.Element, link.URL,
+			strings.Join(link.Rel, " "))
+	}
+}
+
+// Collect the links of a node and its children in document order
+func findLinks(n *html.Node) []Link {
+	links := []Link{}
+	if n.Type == html.ElementNode {
+		links = append(links, elementLinks(n)...)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		links = append(links, findLinks(c)...)
+	}
+	return links
+}
+
+func elementLinks(n *html.Node) []Link {
+	links := []Link{}
+	var rel []string
+	if r, ok := getAttr(n, "rel"); ok {
+		rel = strings.Fields(strings.ToLower(r))
+	}
+	add := func(kind, attr, ref string) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			return
+		}
+		link := Link{
+			URL:     resolveURL(n, ref),
+			Kind:    kind,
+			Element: n.Data,
+			Attr:    attr,
+			Rel:     rel,
+		}
+		for _, r := range rel {
+			if r == "nofollow" {
+				link.Nofollow = true
+			}
+		}
+		links = append(links, link)
+	}
+	addAttr := func(kind, attr string) {
+		if val, ok := getAttr(n, attr); ok {
+			if attr == "srcset" {
+				for _, c := range parseSrcset(val) {
+					add(kind, attr, c.URL)
+				}
+			} else {
+				add(kind, attr, val)
+			}
+		}
+	}
+
+	switch n.DataAtom {
+	case atom.A, atom.Area:
+		addAttr("anchor", "href")
+	case atom.Img:
+		addAttr("image", "src")
+		addAttr("image", "srcset")
+	case atom.Script:
+		addAttr("script", "src")
+	case atom.Link:
+		kind := "link"
+		for _, r := range rel {
+			switch r {
+			case "stylesheet":
+				kind = "stylesheet"
+			case "icon", "apple-touch-icon":
+				kind = "icon"
+			}
+		}
+		addAttr(kind, "href")
+	case atom.Iframe, atom.Frame:
+		addAttr("frame", "src")
+	case atom.Form:
+		addAttr("form", "action")
+	case atom.Button:
+		addAttr("form", "formaction")
+	case atom.Input:
+		addAttr("form", "formaction")
+		if typ, _ := getAttr(n, "type"); strings.EqualFold(typ, "image") {
+			addAttr("image", "src")
+		}
+	case atom.Video, atom.Audio, atom.Track:
+		addAttr("media", "src")
+		addAttr("image", "poster")
+	case atom.Source:
+		// <source> is either part of a <picture> or of a media element
+		if n.Parent != nil && n.Parent.Data == "picture" {
+			addAttr("image", "srcset")
+		} else {
+			addAttr("media", "src")
+		}
+	case atom.Object:
+		addAttr("object", "data")
+	case atom.Embed:
+		addAttr("object", "src")
+	case atom.Blockquote, atom.Q, atom.Del, atom.Ins:
+		addAttr("cite", "cite")
+	case atom.Meta:
+		if equiv, _ := getAttr(n, "http-equiv"); strings.EqualFold(equiv, "refresh") {
+			content, _ := getAttr(n, "content")
+			if ref, ok := parseRefresh(content); ok {
+				add("refresh", "content", ref)
+			}
+		}
+	case atom.Style:
+		var buf bytes.Buffer
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				buf.WriteString(c.Data)
+			}
+		}
+		for _, ref := range cssURLs(buf.String()) {
+			add("css", "", ref)
+		}
+	}
+	if style, ok := getAttr(n, "style"); ok {
+		for _, ref := range cssURLs(style) {
+			add("css", "style", ref)
+		}
+	}
+	return links
+}
+
+var refreshURLRe = regexp.MustCompile(`(?i)^\s*[0-9.]*\s*[;,]\s*(url\s*=\s*)?(.*)$`)
+
+// Find the URL of a meta refresh, e.g. `5; url=http://example.com/`
+func parseRefresh(content string) (string, bool) {
+	match := refreshURLRe.FindStringSubmatch(content)
+	if match == nil {
+		return "", false
+	}
+	ref := strings.TrimSpace(match[2])
+	if len(ref) > 1 && (ref[0] == '"' || ref[0] == '\'') {
+		ref = strings.TrimSuffix(ref[1:], ref[:1])
+	}
+	return ref, ref != ""
+}
+
+var (
+	cssURLRe    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)`)
+	cssImportRe = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// Find the URLs referenced by url() and @import in CSS
+func cssURLs(css string) []string {
+	refs := []string{}
+	for _, re := range []*regexp.Regexp{cssImportRe, cssURLRe} {
+		for _, match := range re.FindAllStringSubmatch(css, -1) {
+			for _, ref := range match[1:] {
+				if ref != "" {
+					refs = append(refs, ref)
+				}
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		// elements
+		{`<a href=" /a ">x</a><a name=b>y</a><a href="">z</a>`, "anchor\ta\t/a\t\n"},
+		{`<a href=/a rel="Nofollow External">x</a>`, "anchor\ta\t/a\tnofollow external\n"},
+		{`<link rel=stylesheet href=a.css><link rel="shortcut icon" href=a.ico><link rel=next href=2>`,
+			"stylesheet\tlink\ta.css\tstylesheet\nicon\tlink\ta.ico\tshortcut icon\nlink\tlink\t2\tnext\n"},
+		{`<script src=a.js></script><iframe src=a.html></iframe><embed src=a.swf><object data=b.swf></object>`,
+			"script\tscript\ta.js\t\nframe\tiframe\ta.html\t\nobject\tembed\ta.swf\t\nobject\tobject\tb.swf\t\n"},
+		{`<form action=/f><input type=image src=a.png formaction=/g><button formaction=/h>x</button></form>`,
+			"form\tform\t/f\t\nform\tinput\t/g\t\nimage\tinput\ta.png\t\nform\tbutton\t/h\t\n"},
+		{`<video src=a.mp4 poster=a.jpg><source src=b.webm><track src=a.vtt></video><blockquote cite=/c>x</blockquote>`,
+			"media\tvideo\ta.mp4\t\nimage\tvideo\ta.jpg\t\nmedia\tsource\tb.webm\t\nmedia\ttrack\ta.vtt\t\ncite\tblockquote\t/c\t\n"},
+
+		// srcset
+		{`<img src=a.png srcset="a-1.png 1x, a-2.png 2x">`,
+			"image\timg\ta.png\t\nimage\timg\ta-1.png\t\nimage\timg\ta-2.png\t\n"},
+		{`<img srcset="a,1.png 100w,b.png, c.png">`,
+			"image\timg\ta,1.png\t\nimage\timg\tb.png\t\nimage\timg\tc.png\t\n"},
+		{`<picture><source srcset="a.webp 1x, b.webp 2x"><img src=a.jpg></picture>`,
+			"image\tsource\ta.webp\t\nimage\tsource\tb.webp\t\nimage\timg\ta.jpg\t\n"},
+
+		// meta refresh
+		{`<meta http-equiv=refresh content="5; url=/next">`, "refresh\tmeta\t/next\t\n"},
+		{`<meta http-equiv=Refresh content="0;URL='/a b'">`, "refresh\tmeta\t/a b\t\n"},
+		{`<meta http-equiv=refresh content="1, /c"><meta http-equiv=refresh content="30"><meta name=refresh content="0; url=/d">`,
+			"refresh\tmeta\t/c\t\n"},
+
+		// CSS
+		{`<style>@import "a.css"; p { background: url( 'b.png' ) } q { background: url(c.png) }</style>`,
+			"css\tstyle\ta.css\t\ncss\tstyle\tb.png\t\ncss\tstyle\tc.png\t\n"},
+		{`<p style='background: url("a.png"), url(b.png); color: red'>x</p>`,
+			"css\tp\ta.png\t\ncss\tp\tb.png\t\n"},
+
+		// URLs are resolved against a <base>
+		{`<base href="https://example.com/a/"><a href=b>x</a><img srcset="/c.png 2x">`,
+			"anchor\ta\thttps://example.com/a/b\t\nimage\timg\thttps://example.com/c.png\t\n"},
+	}
+	for _, test := range tests {
+		output := displayString(t, LinksDisplayer{}, test.input, "html")
+		if output != test.expected {
+			t.Errorf("%s: expected\n%q\ngot\n%q", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestLinksJSON(t *testing.T) {
+	input := `<a href=/a rel="nofollow noopener">x</a><p style="background: url(b.png)">y</p>`
+	var links []Link
+	if err := json.Unmarshal([]byte(displayString(t, LinksDisplayer{JSON: true}, input, "html")), &links); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Link{
+		{URL: "/a", Kind: "anchor", Element: "a", Attr: "href", Rel: []string{"nofollow", "noopener"}, Nofollow: true},
+		{URL: "b.png", Kind: "css", Element: "p", Attr: "style"},
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected\n%+v\ngot\n%+v", expected, links)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The number of problems found by --lint in all documents
+var lintProblems int64
+
+// A parse error the HTML parser recovers from
+type lintProblem struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+	docSource
+	offset int
+}
+
+// Elements whose end tags may be left out
+var optionalEndTags = []atom.Atom{
+	atom.Html, atom.Head, atom.Body, atom.P, atom.Li, atom.Dt, atom.Dd,
+	atom.Option, atom.Optgroup, atom.Rp, atom.Rt,
+	atom.Tr, atom.Td, atom.Th, atom.Tbody, atom.Thead, atom.Tfoot,
+	atom.Colgroup,
+}
+
+// Elements the adoption agency algorithm reopens when they're misnested
+var formattingElements = []atom.Atom{
+	atom.A, atom.B, atom.Big, atom.Code, atom.Em, atom.Font, atom.I,
+	atom.Nobr, atom.S, atom.Small, atom.Strike, atom.Strong, atom.Tt, atom.U,
+}
+
+// Lint an input instead of querying it
+func lintInput(w io.Writer, r io.Reader, src docSource) error {
+	rc, err := decompress(r, pupDecompress)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	br := bufio.NewReader(rc)
+	if isMHTML(br) {
+		return fmt.Errorf("MHTML archives can't be linted")
+	}
+	return lintDecoded(w, br, src, pupCharset, "")
+}
+
+// Report the parse errors in decompressed html. The input is tokenized with
+// the open elements tracked as in streamHTML, so this approximates the
+// errors the HTML parser recovers from rather than finding every one.
+func lintDecoded(w io.Writer, r io.Reader, src docSource, cs, declared string) error {
+	decoded, _, err := decodeHTML(r, cs, declared)
+	if err != nil {
+		return err
+	}
+	// problems are attributed as nodes are by documentSource
+	attributed := docSource{}
+	if pupWithFilename {
+		attributed = src
+	}
+	problems := []lintProblem{}
+	report := func(pos sourcePos, format string, args ...interface{}) {
+		problems = append(problems, lintProblem{
+			Line:      pos.Line,
+			Column:    pos.Column,
+			Message:   fmt.Sprintf(format, args...),
+			docSource: attributed,
+			offset:    pos.Offset,
+		})
+	}
+
+	stack := []*streamElement{{node: &html.Node{Type: html.DocumentNode}}}
+	starts := []sourcePos{{}}
+	// close the top n elements, reporting those whose end tags are required
+	pop := func(n int, by string) {
+		for ; n > 0; n-- {
+			top := len(stack) - 1
+			if open := stack[top].node; !hasAtom(optionalEndTags, open.DataAtom) {
+				report(starts[top], "unclosed element <%s> closed by %s", open.Data, by)
+			}
+			stack, starts = stack[:top], starts[:top]
+		}
+	}
+	push := func(n *html.Node, pos sourcePos) {
+		stack = append(stack, &streamElement{node: n})
+		starts = append(starts, pos)
+	}
+	// the index of the topmost open element with this name, or 0
+	find := func(name string) int {
+		for i := len(stack) - 1; i > 0; i-- {
+			if stack[i].node.Data == name {
+				return i
+			}
+		}
+		return 0
+	}
+
+	pos := startPos
+	z := html.NewTokenizer(decoded)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return z.Err()
+			}
+			break
+		}
+		raw := z.Raw()
+		start := pos
+		pos.advance(raw)
+		tok := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			seen := map[string]bool{}
+			for _, a := range tok.Attr {
+				if seen[a.Key] {
+					report(start, "duplicate attribute '%s' on <%s>, the first is used", a.Key, tok.Data)
+				}
+				seen[a.Key] = true
+			}
+			n := &html.Node{Type: html.ElementNode, Data: tok.Data, DataAtom: tok.DataAtom}
+			foreign := inForeignContent(stack)
+			if tt == html.SelfClosingTagToken && !foreign && !isVoidElement(n) {
+				report(start, "<%s/> isn't a void element, the '/' is ignored", tok.Data)
+			}
+			switch tok.DataAtom {
+			case atom.Html, atom.Head, atom.Body:
+				// these are implied, so repeating them only adds attributes
+				if find(tok.Data) > 0 {
+					continue
+				}
+			case atom.A:
+				if i := find("a"); i > 0 {
+					report(start, "<a> within <a>, the open <a> is closed")
+					stack, starts = append(stack[:i], stack[i+1:]...), append(starts[:i], starts[i+1:]...)
+				}
+			}
+			pop(impliedEnds(stack, tok.DataAtom), "<"+tok.Data+">")
+			for _, a := range impliedStarts(stack[len(stack)-1].node.DataAtom, tok.DataAtom) {
+				report(start, "<%s> within <%s> implies a <%s>", tok.Data, stack[len(stack)-1].node.Data, a)
+				push(&html.Node{Type: html.ElementNode, Data: a.String(), DataAtom: a}, start)
+			}
+			if isVoidElement(n) || (tt == html.SelfClosingTagToken && foreign) {
+				continue
+			}
+			push(n, start)
+		case html.EndTagToken:
+			if len(tok.Attr) > 0 {
+				report(start, "attributes on end tag </%s> are ignored", tok.Data)
+			}
+			i := find(tok.Data)
+			if i == 0 {
+				switch tok.DataAtom {
+				case atom.Html, atom.Head, atom.Body:
+				default:
+					report(start, "stray end tag </%s> is ignored", tok.Data)
+				}
+				continue
+			}
+			if hasAtom(formattingElements, tok.DataAtom) && i < len(stack)-1 {
+				// the parser closes the formatting element and reopens it
+				// within the elements still open
+				report(start, "misnested end tag </%s>, <%s> is still open", tok.Data, stack[len(stack)-1].node.Data)
+				stack, starts = append(stack[:i], stack[i+1:]...), append(starts[:i], starts[i+1:]...)
+				continue
+			}
+			pop(len(stack)-1-i, "</"+tok.Data+">")
+			stack, starts = stack[:i], starts[:i]
+		case html.TextToken:
+			switch stack[len(stack)-1].node.DataAtom {
+			case atom.Table, atom.Tbody, atom.Thead, atom.Tfoot, atom.Tr:
+				if strings.TrimSpace(tok.Data) != "" {
+					report(start, "text within <%s> is moved before the table", stack[len(stack)-1].node.Data)
+				}
+			}
+		}
+	}
+	for i := len(stack) - 1; i > 0; i-- {
+		if open := stack[i].node; !hasAtom(optionalEndTags, open.DataAtom) {
+			report(starts[i], "unclosed element <%s>", open.Data)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].offset < problems[j].offset
+	})
+	atomic.AddInt64(&lintProblems, int64(len(problems)))
+	if pupLint == "json" {
+		data, err := json.MarshalIndent(problems, "", pupIndentString)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", data)
+		return nil
+	}
+	w = resultWriter(w, src)
+	for _, p := range problems {
+		fmt.Fprintf(w, "%d:%d: %s\n", p.Line, p.Column, p.Message)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	input := "<div id=a id=b><span>x</div>\n" +
+		"<p><b><i>y</b></i></p>\n" +
+		"<table><tr><td>z</table></section>\n" +
+		"<ul><li>café <em>a<li>b</ul><svg><path/></svg><br/>"
+	expected := "1:1: duplicate attribute 'id' on <div>, the first is used\n" +
+		"1:16: unclosed element <span> closed by </div>\n" +
+		"2:11: misnested end tag </b>, <i> is still open\n" +
+		"3:8: <tr> within <table> implies a <tbody>\n" +
+		"3:25: stray end tag </section> is ignored\n" +
+		"4:14: unclosed element <em> closed by <li>\n"
+
+	var buf bytes.Buffer
+	if err := lintDecoded(&buf, strings.NewReader(input), docSource{}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
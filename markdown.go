@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Print nodes as Markdown. By default GitHub Flavored Markdown is written,
+// `markdown{commonmark}` writes tables and strikethrough as plain CommonMark.
+type MarkdownDisplayer struct {
+	GFM bool
+}
+
+func (m MarkdownDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	blocks := m.convert(nodes)
+	if len(blocks) > 0 {
+		fmt.Fprintln(w, strings.Join(blocks, "\n\n"))
+	}
+}
+
+// Convert a list of sibling nodes to Markdown blocks. Runs of inline nodes
+// are gathered into paragraphs.
+func (m MarkdownDisplayer) convert(nodes []*html.Node) []string {
+	blocks := []string{}
+	var inline bytes.Buffer
+	flush := func() {
+		if p := markdownParagraph(inline.String()); p != "" {
+			blocks = append(blocks, p)
+		}
+		inline.Reset()
+	}
+	for _, n := range nodes {
+		if n.Type == html.DocumentNode || isBlockElement(n) {
+			flush()
+			blocks = append(blocks, m.block(n)...)
+		} else {
+			inline.WriteString(m.inline(n))
+		}
+	}
+	flush()
+	return blocks
+}
+
+func (m MarkdownDisplayer) convertChildren(n *html.Node) []string {
+	return m.convert(children(n))
+}
+
+// Convert a block level node
+func (m MarkdownDisplayer) block(n *html.Node) []string {
+	if n.Type == html.ElementNode && isHiddenElement(n) {
+		return nil
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		text := strings.Join(m.convertChildren(n), " ")
+		text = strings.Replace(text, "  \n", " ", -1)
+		if text == "" {
+			return nil
+		}
+		return []string{strings.Repeat("#", level) + " " + text}
+	case atom.Ul, atom.Ol:
+		return m.list(n)
+	case atom.Pre:
+		return []string{m.codeBlock(n)}
+	case atom.Blockquote:
+		return []string{prefixLines(strings.Join(m.convertChildren(n), "\n\n"), "> ", "> ")}
+	case atom.Hr:
+		return []string{"---"}
+	case atom.Table:
+		return m.table(n)
+	}
+	return m.convertChildren(n)
+}
+
+// Convert an inline node
+func (m MarkdownDisplayer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return escapeMarkdown(collapseSpace(n.Data))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+	if isHiddenElement(n) {
+		return ""
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		return "  \n"
+	case atom.Strong, atom.B:
+		return wrapInline(m.inlineChildren(n), "**")
+	case atom.Em, atom.I:
+		return wrapInline(m.inlineChildren(n), "*")
+	case atom.Del, atom.S, atom.Strike:
+		if m.GFM {
+			return wrapInline(m.inlineChildren(n), "~~")
+		}
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		var buf bytes.Buffer
+		writeRawText(&buf, n)
+		return codeSpan(collapseSpace(buf.String()))
+	case atom.A:
+		text := strings.TrimSpace(m.inlineChildren(n))
+		href, ok := getAttr(n, "href")
+		if !ok || text == "" {
+			return text
+		}
+		return "[" + text + "](" + markdownLink(n, href) + ")"
+	case atom.Img:
+		src, ok := getAttr(n, "src")
+		if !ok {
+			return ""
+		}
+		alt, _ := getAttr(n, "alt")
+		return "![" + escapeMarkdown(collapseSpace(alt)) + "](" + markdownLink(n, src) + ")"
+	}
+	return m.inlineChildren(n)
+}
+
+func (m MarkdownDisplayer) inlineChildren(n *html.Node) string {
+	var buf bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(m.inline(c))
+	}
+	return buf.String()
+}
+
+// Convert a <ul> or <ol>. Lists where every item is a single line are kept
+// tight, otherwise items are separated by blank lines.
+func (m MarkdownDisplayer) list(n *html.Node) []string {
+	num := 1
+	start, _ := getAttr(n, "start")
+	if start, err := strconv.Atoi(start); err == nil {
+		num = start
+	}
+	items := []string{}
+	tight := true
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li || isHiddenElement(c) {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", num)
+			num++
+		}
+		var body bytes.Buffer
+		for i, block := range m.convertChildren(c) {
+			if i > 0 {
+				// a nested list directly follows its item's text
+				if isMarkdownList(block) && !strings.Contains(body.String(), "\n\n") {
+					body.WriteString("\n")
+				} else {
+					body.WriteString("\n\n")
+					tight = false
+				}
+			}
+			body.WriteString(block)
+		}
+		indent := strings.Repeat(" ", len(marker))
+		items = append(items, prefixLines(body.String(), marker, indent))
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	if tight {
+		return []string{strings.Join(items, "\n")}
+	}
+	return []string{strings.Join(items, "\n\n")}
+}
+
+var markdownListRe = regexp.MustCompile(`\A(- |\d+\. )`)
+
+func isMarkdownList(block string) bool {
+	return markdownListRe.MatchString(block)
+}
+
+// Convert a <pre> to a fenced code block. Unless --pre is given the code is
+// trimmed and dedented.
+func (m MarkdownDisplayer) codeBlock(n *html.Node) string {
+	var buf bytes.Buffer
+	writeRawText(&buf, n)
+	code := buf.String()
+	if !pupPreformatted {
+		code = dedent(code)
+	}
+	code = strings.TrimSuffix(code, "\n")
+	lang := ""
+	for _, c := range append([]*html.Node{n}, children(n)...) {
+		class, _ := getAttr(c, "class")
+		for _, field := range strings.Fields(class) {
+			if strings.HasPrefix(field, "language-") {
+				lang = field[len("language-"):]
+			} else if strings.HasPrefix(field, "lang-") {
+				lang = field[len("lang-"):]
+			}
+		}
+	}
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + code + "\n" + fence
+}
+
+// Convert a table. Without GFM tables are kept as HTML.
+func (m MarkdownDisplayer) table(n *html.Node) []string {
+	if !m.GFM {
+		var buf bytes.Buffer
+		html.Render(&buf, n)
+		return []string{buf.String()}
+	}
+	rows := [][]string{}
+	aligns := []string{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || isHiddenElement(c) {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Tr:
+				row := []string{}
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom != atom.Td && cell.DataAtom != atom.Th {
+						continue
+					}
+					text := strings.Join(m.convertChildren(cell), " ")
+					text = strings.Replace(text, "  \n", " ", -1)
+					text = strings.Replace(text, "\n", " ", -1)
+					text = strings.Replace(text, "|", `\|`, -1)
+					row = append(row, text)
+					if len(rows) == 0 {
+						aligns = append(aligns, cellAlign(cell))
+					}
+				}
+				rows = append(rows, row)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return nil
+	}
+	widths := []int{}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 3)
+			}
+			if w := utf8.RuneCountInString(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	writeRow := func(buf *bytes.Buffer, row []string) {
+		buf.WriteString("|")
+		for i, w := range widths {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			buf.WriteString(" " + cell + strings.Repeat(" ", w-utf8.RuneCountInString(cell)) + " |")
+		}
+	}
+	var buf bytes.Buffer
+	// the first row is always used as the header
+	writeRow(&buf, rows[0])
+	buf.WriteString("\n|")
+	for i, w := range widths {
+		align := ""
+		if i < len(aligns) {
+			align = aligns[i]
+		}
+		dashes := strings.Repeat("-", w)
+		switch align {
+		case "left":
+			dashes = ":" + dashes[1:]
+		case "right":
+			dashes = dashes[1:] + ":"
+		case "center":
+			dashes = ":" + dashes[2:] + ":"
+		}
+		buf.WriteString(" " + dashes + " |")
+	}
+	for _, row := range rows[1:] {
+		buf.WriteString("\n")
+		writeRow(&buf, row)
+	}
+	return []string{buf.String()}
+}
+
+// Alignment of a table cell from its align attribute or style
+func cellAlign(n *html.Node) string {
+	if align, ok := getAttr(n, "align"); ok {
+		return strings.ToLower(align)
+	}
+	style, _ := getAttr(n, "style")
+	style = strings.ToLower(strings.Replace(style, " ", "", -1))
+	for _, align := range []string{"left", "right", "center"} {
+		if strings.Contains(style, "text-align:"+align) {
+			return align
+		}
+	}
+	return ""
+}
+
+// Format a link destination and optional title
+func markdownLink(n *html.Node, dest string) string {
+	if strings.ContainsAny(dest, " ()<>") {
+		dest = "<" + strings.Replace(dest, ">", "%3E", -1) + ">"
+	}
+	if title, ok := getAttr(n, "title"); ok && title != "" {
+		dest += ` "` + strings.Replace(collapseSpace(title), `"`, `\"`, -1) + `"`
+	}
+	return dest
+}
+
+// Turn gathered inline text into a paragraph
+func markdownParagraph(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimLeft(line, " ")
+		// text which would otherwise start a block must be escaped
+		if lines[i] != "" && strings.ContainsAny(lines[i][:1], "#+=-") {
+			lines[i] = `\` + lines[i]
+		}
+		lines[i] = markdownOrderedRe.ReplaceAllString(lines[i], `$1\$2$3`)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+var markdownOrderedRe = regexp.MustCompile(`\A(\d{1,9})([.)])(\s|\z)`)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`",
+	"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// Put a marker around inline text, keeping surrounding whitespace outside of
+// it so the emphasis is still recognized
+func wrapInline(s, marker string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	i := strings.Index(s, trimmed)
+	return s[:i] + marker + trimmed + marker + s[i+len(trimmed):]
+}
+
+// Format text as a code span using a fence longer than any run of backticks
+// in the text
+func codeSpan(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return s
+	}
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+var spaceRe = regexp.MustCompile(`[ \t\r\n\f]+`)
+
+// Collapse runs of whitespace into a single space
+func collapseSpace(s string) string {
+	return spaceRe.ReplaceAllString(s, " ")
+}
+
+// Prefix the first line of s with first and every following line with rest
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Remove surrounding blank lines and common leading whitespace
+func dedent(s string) string {
+	lines := strings.Split(s, "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	common := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if common < 0 || indent < common {
+			common = indent
+		}
+	}
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		} else if common > 0 {
+			lines[i] = line[common:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Collect the children of a node
+func children(n *html.Node) []*html.Node {
+	nodes := []*html.Node{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nodes = append(nodes, c)
+	}
+	return nodes
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		input    string
+		gfm      bool
+		expected string
+	}{
+		// inline text
+		{`<p>a <b>b</b> <em> c </em> <code>d</code> <s>e</s></p>`, true, "a **b**  *c*  `d` ~~e~~"},
+		{"<p><s>e</s> <code>a`b</code></p>", false, "e ``a`b``"},
+		{`<p>* a_b [c] <a href="/x y" title=t>d</a> <img src=e.png alt=e></p>`, true,
+			`\* a\_b \[c\] [d](</x y> "t") ![e](e.png)`},
+		{`<p>a<br>b</p><p># c</p><p>1. d</p>`, true, "a  \nb\n\n\\# c\n\n1\\. d"},
+		{`<h2>a <i>b</i></h2><hr><blockquote><p>c</p><p>d</p></blockquote>`, true, "## a *b*\n\n---\n\n> c\n>\n> d"},
+
+		// lists
+		{`<ul><li>a<li>b</ul>`, true, "- a\n- b"},
+		{`<ol start=3><li>a<li>b</ol>`, true, "3. a\n4. b"},
+		{`<ul><li>a<ul><li>b<ol><li>c</ol></ul><li>d</ul>`, true, "- a\n  - b\n    1. c\n- d"},
+		{`<ol><li>a<ul><li>b</ul></ol>`, true, "1. a\n   - b"},
+		{`<ul><li><p>a</p><p>b</p><li>c</ul>`, true, "- a\n\n  b\n\n- c"},
+		{`<ul><li>a<li hidden>b<li style="display: none">c</ul>`, true, "- a"},
+
+		// preformatted text
+		{"<pre>\n    a\n      b\n</pre>", true, "```\na\n  b\n```"},
+		{"<pre><code class=language-go>a := `b`\n```</code></pre>", true, "````go\na := `b`\n```\n````"},
+		{"<pre class=lang-sh>a <b>b</b></pre>", true, "```sh\na b\n```"},
+
+		// tables
+		{`<table><tr><th>a<th align=right>bb<th style="text-align: center">c</tr><tr><td>1<td>2<td>3 | 4</tr></table>`, true,
+			"| a   | bb  | c      |\n| --- | --: | :----: |\n| 1   | 2   | 3 \\| 4 |"},
+		{`<table><thead><tr><td>a<td>b</thead><tbody><tr><td>c<br>d</tbody></table>`, true,
+			"| a   | b   |\n| --- | --- |\n| c d |     |"},
+		{`<table><tr><td>a</td></tr></table>`, false, "<table><tbody><tr><td>a</td></tr></tbody></table>"},
+	}
+	for _, test := range tests {
+		output := displayString(t, MarkdownDisplayer{GFM: test.gfm}, test.input, "body")
+		if output = strings.TrimSuffix(output, "\n"); output != test.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.input, test.expected, output)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// The locations of the parts of an MHTML archive, used to resolve references
+// in its HTML against the URLs the parts were saved from
+type mhtmlArchive struct {
+	// Content-Location of the HTML part
+	Location *url.URL
+	// Content-Location of each part by Content-ID
+	Parts map[string]string
+}
+
+var (
+	mhtmlArchivesMu sync.Mutex
+	mhtmlArchives   = map[*html.Node]*mhtmlArchive{}
+)
+
+// The archive a node's document was read from, or nil if it wasn't MHTML or
+// --mhtml-locations wasn't given
+func documentArchive(n *html.Node) *mhtmlArchive {
+	if !pupMHTMLLocations {
+		return nil
+	}
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	mhtmlArchivesMu.Lock()
+	defer mhtmlArchivesMu.Unlock()
+	return mhtmlArchives[root]
+}
+
+var mhtmlHeaderRe = regexp.MustCompile(`(?im)^content-type:[ \t]*multipart/related`)
+
+// Does the input start with the MIME headers of a multipart/related
+// message? Only the headers before the first blank line are looked at.
+func isMHTML(r *bufio.Reader) bool {
+	peek, _ := r.Peek(8192)
+	return isMHTMLHeader(peek)
+}
+
+func isMHTMLHeader(peek []byte) bool {
+	header := string(peek)
+	for _, sep := range []string{"\r\n\r\n", "\n\n"} {
+		if i := strings.Index(header, sep); i >= 0 {
+			header = header[:i]
+		}
+	}
+	// HTML never starts with a header field
+	if i := strings.IndexAny(header, ":\r\n"); i <= 0 || header[i] != ':' {
+		return false
+	}
+	return mhtmlHeaderRe.MatchString(header)
+}
+
+// Parse the HTML part of an MHTML archive. This is the part named by the
+// start parameter, or the first HTML part.
+func parseMHTML(r *bufio.Reader, cs string) (*html.Node, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MHTML header: %s", err.Error())
+	}
+	_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return nil, fmt.Errorf("Invalid MHTML content type '%s'", header.Get("Content-Type"))
+	}
+	start := strings.Trim(params["start"], "<>")
+
+	archive := &mhtmlArchive{Parts: map[string]string{}}
+	var body []byte
+	var declared string
+	var found bool
+	mr := multipart.NewReader(r, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid MHTML part: %s", err.Error())
+		}
+		id := strings.Trim(part.Header.Get("Content-ID"), "<>")
+		location := part.Header.Get("Content-Location")
+		if id != "" && location != "" {
+			archive.Parts[id] = location
+		}
+		typ, partParams, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if found || typ != "text/html" || (start != "" && id != start) {
+			continue
+		}
+		found = true
+		if body, err = readPart(part); err != nil {
+			return nil, fmt.Errorf("Invalid MHTML part: %s", err.Error())
+		}
+		if u, err := url.Parse(location); err == nil && location != "" {
+			archive.Location = u
+		}
+		declared = partParams["charset"]
+	}
+	if !found {
+		return nil, fmt.Errorf("MHTML archive has no HTML part")
+	}
+
+	root, err := parseDecoded(bytes.NewReader(body), cs, declared)
+	if err != nil {
+		return nil, err
+	}
+	mhtmlArchivesMu.Lock()
+	mhtmlArchives[root] = archive
+	mhtmlArchivesMu.Unlock()
+	return root, nil
+}
+
+// Read the body of a part. Quoted-printable is decoded by mime/multipart.
+func readPart(part *multipart.Part) ([]byte, error) {
+	var r io.Reader = part
+	if strings.EqualFold(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding")), "base64") {
+		r = base64.NewDecoder(base64.StdEncoding, part)
+	}
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const testMHTML = "From: <Saved by Blink>\r\n" +
+	"Snapshot-Content-Location: https://example.com/docs/\r\n" +
+	"Subject: Example\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/related;\r\n" +
+	"\ttype=\"text/html\";\r\n" +
+	"\tboundary=\"----MultipartBoundary--abc----\"\r\n" +
+	"\r\n" +
+	"------MultipartBoundary--abc----\r\n" +
+	"Content-Type: text/html; charset=iso-8859-1\r\n" +
+	"Content-ID: <frame-1@mhtml.blink>\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"Content-Location: https://example.com/docs/\r\n" +
+	"\r\n" +
+	"<html><body><p>caf=E9</p><a href=3D\"../about\">about</a><img src=3D\"cid:img-1=\r\n" +
+	"@mhtml.blink\"></body></html>\r\n" +
+	"------MultipartBoundary--abc----\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <img-1@mhtml.blink>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-Location: https://example.com/logo.png\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"------MultipartBoundary--abc------\r\n"
+
+func TestParseMHTML(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(testMHTML))
+	if !isMHTML(r) {
+		t.Fatal("expected input to be detected as MHTML")
+	}
+	if isMHTML(bufio.NewReader(strings.NewReader("<!DOCTYPE html>\n<p>Content-Type: multipart/related</p>"))) {
+		t.Error("expected HTML not to be detected as MHTML")
+	}
+	root, err := parseMHTML(r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := nodeText(root); text != "caféabout" {
+		t.Errorf("expected %q got %q", "caféabout", text)
+	}
+
+	pupMHTMLLocations = true
+	defer func() { pupMHTMLLocations = false }()
+	a, img := findByTag(root, "a"), findByTag(root, "img")
+	href, _ := getAttr(a, "href")
+	src, _ := getAttr(img, "src")
+	if got := resolveURL(a, href); got != "https://example.com/about" {
+		t.Errorf("expected %s got %s", "https://example.com/about", got)
+	}
+	if got := resolveURL(img, src); got != "https://example.com/logo.png" {
+		t.Errorf("expected %s got %s", "https://example.com/logo.png", got)
+	}
+}
+
+// attr{} prints the locations of an archive's links and parts with
+// --mhtml-locations, as other display functions do
+func TestMHTMLAttr(t *testing.T) {
+	pupMHTMLLocations = true
+	defer func() { pupMHTMLLocations = false }()
+	root, err := parseMHTML(bufio.NewReader(strings.NewReader(testMHTML)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseDocument(root)
+	cmds, _ := ParseCommands("a, img")
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	AttrDisplayer{Attrs: []string{"href", "src"}}.Display(&buf, SelectNodes(root, selectorFuncs))
+	expected := "https://example.com/about\t\n\thttps://example.com/logo.png\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q got %q", expected, buf.String())
+	}
+}
+
+func findByTag(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findByTag(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements whose text children can only be whitespace between elements
+var spaceOnlyElements = map[string]bool{
+	"colgroup": true, "frameset": true, "head": true, "html": true,
+	"optgroup": true, "select": true, "table": true, "tbody": true,
+	"tfoot": true, "thead": true, "tr": true,
+}
+
+// Attributes whose presence is their value, so disabled="disabled" can be
+// written disabled
+var booleanAttrs = map[string]bool{
+	"allowfullscreen": true, "async": true, "autofocus": true,
+	"autoplay": true, "checked": true, "controls": true, "default": true,
+	"defer": true, "disabled": true, "formnovalidate": true, "hidden": true,
+	"inert": true, "ismap": true, "itemscope": true, "loop": true,
+	"multiple": true, "muted": true, "nomodule": true, "novalidate": true,
+	"open": true, "playsinline": true, "readonly": true, "required": true,
+	"reversed": true, "selected": true,
+}
+
+// Elements whose end tag closes the <p>, <li>, <dd> and <dt> elements
+// within them. Their end tags can only be left out before the end of one of
+// these, as the end tag of other elements is ignored while they're open.
+var closingParents = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "button": true, "caption": true, "center": true,
+	"dd": true, "details": true, "dialog": true, "dir": true, "div": true,
+	"dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "header": true,
+	"hgroup": true, "html": true, "li": true, "listing": true, "main": true,
+	"menu": true, "nav": true, "ol": true, "pre": true, "section": true,
+	"summary": true, "td": true, "template": true, "th": true, "ul": true,
+}
+
+// Elements whose start tag closes an open <p>. <table> is left out, as it
+// doesn't in quirks mode.
+var closesParagraph = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"details": true, "div": true, "dl": true, "fieldset": true,
+	"figcaption": true, "figure": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hgroup": true, "hr": true, "main": true, "menu": true,
+	"nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"ul": true,
+}
+
+// Is a comment an Internet Explorer conditional comment, e.g.
+// <!--[if IE]>...<![endif]--> or the <!--[if !IE]><!--> and <!--<![endif]-->
+// around downlevel-revealed markup?
+func isConditionalComment(n *html.Node) bool {
+	return n.Type == html.CommentNode &&
+		(strings.HasPrefix(n.Data, "[if") || strings.HasPrefix(n.Data, "<![endif]"))
+}
+
+// Remove the collapsed whitespace of a text node which isn't rendered: the
+// whitespace next to block elements, at the start or end of a block and
+// between the elements of a table or the <head>
+func trimBlockSpace(n *html.Node, s string) string {
+	parent := n.Parent
+	if parent != nil && parent.Type == html.ElementNode && spaceOnlyElements[parent.Data] && strings.TrimSpace(s) == "" {
+		return ""
+	}
+	inBlock := parent == nil || parent.Type != html.ElementNode || isBlockElement(parent)
+	if prev := n.PrevSibling; prev == nil && inBlock || prev != nil && isBlockElement(prev) {
+		s = strings.TrimPrefix(s, " ")
+	}
+	if next := n.NextSibling; next == nil && inBlock || next != nil && isBlockElement(next) {
+		s = strings.TrimSuffix(s, " ")
+	}
+	return s
+}
+
+// The sibling written after a node when shortening, skipping comments and
+// whitespace which are left out
+func nextWritten(n *html.Node) *html.Node {
+	next := n.NextSibling
+	for next != nil {
+		switch {
+		case next.Type == html.CommentNode && !isConditionalComment(next):
+		case next.Type == html.TextNode && !preservesSpace(next.Parent) &&
+			trimBlockSpace(next, collapseSpace(next.Data)) == "":
+		default:
+			return next
+		}
+		next = next.NextSibling
+	}
+	return nil
+}
+
+// Can the end tag of an element be left out? These are the rules of the
+// HTML standard, which assume the element is where it's allowed to be, so
+// elements which close at the end of their parent only leave it out if
+// the parser would close them there.
+func canOmitEndTag(n *html.Node) bool {
+	if n.Namespace != "" {
+		return false
+	}
+	next := nextWritten(n)
+	nextName := ""
+	if next != nil && next.Type == html.ElementNode && next.Namespace == "" {
+		nextName = next.Data
+	}
+	parentName := ""
+	if n.Parent != nil && n.Parent.Type == html.ElementNode {
+		parentName = n.Parent.Data
+	}
+	last := next == nil
+	closed := last && (parentName == "" || closingParents[parentName])
+	switch n.Data {
+	case "html", "body":
+		return next == nil || next.Type != html.CommentNode
+	case "head", "caption", "colgroup":
+		return next == nil || next.Type == html.ElementNode
+	case "li":
+		return nextName == "li" || closed
+	case "dt":
+		return nextName == "dt" || nextName == "dd"
+	case "dd":
+		return nextName == "dd" || nextName == "dt" || closed
+	case "p":
+		return closesParagraph[nextName] || closed
+	case "rt", "rp":
+		return nextName == "rt" || nextName == "rp" || last
+	case "optgroup":
+		return nextName == "optgroup" || last
+	case "option":
+		return nextName == "option" || nextName == "optgroup" || last
+	case "thead":
+		return nextName == "tbody" || nextName == "tfoot"
+	case "tbody":
+		return nextName == "tbody" || nextName == "tfoot" || last
+	case "tfoot":
+		return last
+	case "tr":
+		return nextName == "tr" || last
+	case "td", "th":
+		return nextName == "td" || nextName == "th" || last
+	}
+	return false
+}
+
+// Write an attribute as briefly as it can be: without a value if it's empty
+// or a boolean attribute, and without quotes if the value allows
+func writeShortAttr(w *bytes.Buffer, a html.Attribute) {
+	name := attrName(a)
+	if a.Val == "" || booleanAttrs[name] && strings.EqualFold(a.Val, name) {
+		w.WriteString(" " + name)
+		return
+	}
+	val := exactAttrEscaper.Replace(a.Val)
+	if strings.ContainsAny(a.Val, " \t\n\f\r\"'=<>`") {
+		w.WriteString(" " + name + `="` + val + `"`)
+		return
+	}
+	w.WriteString(" " + name + "=" + val)
+}
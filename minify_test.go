@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+var minifyStyle = htmlStyle{Minify: true, Exact: true, Shorten: true}
+
+func minifyString(t *testing.T, input string) string {
+	root, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeHTML(&buf, root, minifyStyle, false)
+	return buf.String()
+}
+
+// Describe a tree with whitespace collapsed and comments other than
+// conditional comments removed, as minifying leaves it
+func minifiedTree(w *bytes.Buffer, n *html.Node, depth int) {
+	indent := strings.Repeat(" ", depth)
+	switch n.Type {
+	case html.TextNode:
+		s := n.Data
+		if !preservesSpace(n.Parent) {
+			s = strings.Join(strings.Fields(s), " ")
+		}
+		if s != "" {
+			fmt.Fprintf(w, "%s%q\n", indent, s)
+		}
+	case html.CommentNode:
+		if isConditionalComment(n) {
+			fmt.Fprintf(w, "%s<!--%s-->\n", indent, n.Data)
+		}
+	case html.DoctypeNode:
+		fmt.Fprintf(w, "%s<!DOCTYPE %s>\n", indent, n.Data)
+	case html.ElementNode:
+		fmt.Fprintf(w, "%s<%s", indent, n.Data)
+		for _, a := range n.Attr {
+			val := a.Val
+			if booleanAttrs[a.Key] && strings.EqualFold(val, a.Key) {
+				val = ""
+			}
+			fmt.Fprintf(w, " %s=%q", attrName(a), val)
+		}
+		fmt.Fprintln(w, ">")
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		minifiedTree(w, c, depth+1)
+	}
+}
+
+func TestMinify(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		{"<p>a  \n b</p>\n<p> c </p>", `<html><head><body><p>a b<p>c`},
+		{"<ul>\n <li>one</li>\n <li>two <b>2</b> </li>\n</ul>", `<html><head><body><ul><li>one<li>two <b>2</b></ul>`},
+		{"<b>a</b> <i>b</i>", `<html><head><body><b>a</b> <i>b</i>`},
+		{"<pre>\n\n a  b </pre><textarea> x  y </textarea>", "<html><head><body><pre>\n\n a  b </pre><textarea> x  y </textarea>"},
+		{"<script> if (a  <  b) {} </script><style> p  { } </style>", `<html><head><script> if (a  <  b) {} </script><style> p  { } </style><body>`},
+		{"<!-- a --><p>a<!--[if IE]><b>ie</b><![endif]--></p>", `<html><head><body><p>a<!--[if IE]><b>ie</b><![endif]-->`},
+		{`<input type="text" value="" disabled="disabled" checked>`, `<html><head><body><input type=text value disabled checked>`},
+		{`<a href="a b" title='"x"' class="=">a</a>`, `<html><head><body><a href="a b" title="&quot;x&quot;" class="=">a</a>`},
+		{`<p>a</p>b`, `<html><head><body><p>a</p>b`},
+		{`<p>a</p><span>b</span>`, `<html><head><body><p>a</p><span>b</span>`},
+		{`<span><p>a</p></span>`, `<html><head><body><span><p>a</p></span>`},
+		{`<a><p>a</p></a>`, `<html><head><body><a><p>a</p></a>`},
+		{`<dl><dt>a</dt><dd>b</dd><dt>c</dt></dl>`, `<html><head><body><dl><dt>a<dd>b<dt>c</dt></dl>`},
+		{`<table><caption>c</caption><tr><td>1</td><th>2</th></tr></table>`, `<html><head><body><table><caption>c<tbody><tr><td>1<th>2</table>`},
+		{`<table><thead><tr><td>h</td></tr></thead><tfoot><tr><td>f</td></tr></tfoot></table>`,
+			`<html><head><body><table><thead><tr><td>h<tfoot><tr><td>f</table>`},
+		{`<select><optgroup label=x><option>a</option></optgroup><option>b</option></select>`,
+			`<html><head><body><select><optgroup label=x><option>a</optgroup><option>b</select>`},
+		{"<html><body><p>a</p></body></html><!-- after -->", `<html><head><body><p>a`},
+		{"<html><body><p>a</p></body><!--[if IE]>x<![endif]--></html>", `<html><head><body><p>a</body><!--[if IE]>x<![endif]-->`},
+	}
+	for _, test := range tests {
+		if output := minifyString(t, test.input); output != test.expected {
+			t.Errorf("%q: expected\n%s\ngot\n%s", test.input, test.expected, output)
+		}
+	}
+}
+
+// Minified documents must parse to the same tree as the originals, apart
+// from whitespace and comments
+func TestMinifyReparse(t *testing.T) {
+	inputs := []string{
+		`<!DOCTYPE html><html lang=en><head><meta charset=utf-8><title>x</title></head><body><p>a</p></body></html>`,
+		"<ul>\n<li>a<ul><li>b</li></ul></li>\n<li><p>c</p></li>\n</ul>\n<ol><li>d</ol>",
+		"<div><p>a</p><div>b</div><p>c</p><h2>d</h2><p>e</p><table><tr><td>f</td></tr></table><p>g</p></div>",
+		"<p>a<p>b<section><p>c</section><blockquote><p>d</blockquote>",
+		"<button><p>a</p></button><li><p>b</p></li><td><p>c</p></td>",
+		"<table>\n<colgroup><col><col></colgroup>\n<colgroup><col></colgroup>\n<thead><tr><th>a<th>b</thead>\n<tbody><tr><td>1<td>2</tbody>\n<tbody><tr><td>3<td>4</tbody>\n<tfoot><tr><td>5</tfoot></table>",
+		"<dl><dt>a<dt>b<dd>c<dd>d</dl><div><dt>e<dd>f</div>",
+		"<ruby>a<rp>(</rp><rt>b</rt><rp>)</rp></ruby><select><option>a<option selected>b<optgroup><option>c</optgroup></select>",
+		"<p>a <b>b</b> <i>c</i>\n<a href='x y'>d</a>\n<img src=\"a.png\" alt=\"\">\n<br>\ne</p>",
+		"<pre>\n  a\n</pre><textarea>\n b </textarea><listing>\nc</listing><script>if (a < b) {}</script><style>p > a {}</style>",
+		"<!--[if IE]><p>ie</p><![endif]--><!--[if !IE]><!--><p>other</p><!--<![endif]--><!-- gone -->",
+		"<input value='a=b' data-x=\"'\" title=\"`\" disabled=DISABLED hidden=hidden>",
+		"<p>a</p><svg><circle r=1></circle><text>b</text></svg><math><mi>c</mi></math><p>d",
+		"<span><p>a</span><em><li>b</em><a><p>c</p></a>",
+		"<video><p>a</p></video><del><p>b</p></del><noscript><p>c</p></noscript>",
+		"<head> <title>a</title> <!-- x --> </head> <body> text </body> <!-- y -->",
+		"<table><caption>a</caption> <!-- c --> <tr><td>b</table>",
+		"<form><fieldset><legend>a</legend><label>b <input name=c></label></fieldset></form><p>d</p>",
+	}
+	page, err := ioutil.ReadFile("tests/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs = append(inputs, string(page))
+	for _, input := range inputs {
+		root, err := html.Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		minified := minifyString(t, input)
+		reparsed, err := html.Parse(strings.NewReader(minified))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected, got bytes.Buffer
+		minifiedTree(&expected, root, 0)
+		minifiedTree(&got, reparsed, 0)
+		if expected.String() != got.String() {
+			t.Errorf("%.200q minified to %.200q which parses to\n%s\ninstead of\n%s", input, minified, got.String(), expected.String())
+		}
+	}
+}
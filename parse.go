@@ -1,63 +1,189 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
 	"golang.org/x/text/transform"
 )
 
 var (
-	pupIn            io.ReadCloser = os.Stdin
-	pupCharset       string        = ""
-	pupMaxPrintLevel int           = -1
-	pupPreformatted  bool          = false
-	pupPrintColor    bool          = false
-	pupEscapeHTML    bool          = true
-	pupIndentString  string        = " "
-	pupDisplayer     Displayer     = TreeDisplayer{}
+	pupInputs           []string        = nil
+	pupCharset          string          = ""
+	pupMaxPrintLevel    int             = -1
+	pupPreformatted     bool            = false
+	pupPrintColor       bool            = false
+	pupEscapeHTML       bool            = true
+	pupIndentString     string          = " "
+	pupWidth            int             = 80
+	pupPrintMissing     bool            = false
+	pupMissing          string          = ""
+	pupDisplayer        Displayer       = TreeDisplayer{}
+	pupBaseURL          *url.URL        = nil
+	pupWithFilename     bool            = false
+	pupFilesWithMatches bool            = false
+	pupJobs             int             = 1
+	pupDecompress       string          = ""
+	pupWarcs            []string        = nil
+	pupWarcURL          *regexp.Regexp  = nil
+	pupMHTMLLocations   bool            = false
+	pupShowCharset      bool            = false
+	pupCharsetFallback  string          = "windows-1252"
+	pupStream           bool            = false
+	pupFragment         string          = ""
+	pupXML              bool            = false
+	pupLint             string          = ""
+	pupWithPositions    bool            = false
+	pupEdits            []editAction    = nil
+	pupInPlace          bool            = false
+	pupBackupSuffix     string          = ""
+	pupDiff             bool            = false
+	pupSanitize         *sanitizePolicy = nil
+	pupMinify           bool            = false
 )
 
-// Parse the html while handling the charset
+// Parse the html while handling compression, MHTML archives and the charset
 func ParseHTML(r io.Reader, cs string) (*html.Node, error) {
-	var err error
-	if cs == "" {
-		// attempt to guess the charset of the HTML document
-		r, err = charset.NewReader(r, "")
-		if err != nil {
-			return nil, err
-		}
+	// decompress first so the charset is guessed from the decoded bytes
+	rc, err := decompress(r, pupDecompress)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	br := bufio.NewReader(rc)
+	if isMHTML(br) {
+		return parseMHTML(br, cs)
+	}
+	return parseDecoded(br, cs, "")
+}
+
+// Parse decompressed html while handling the charset. cs is the charset the
+// user specified and declared the one given by a Content-Type header, if any.
+func parseDecoded(r io.Reader, cs, declared string) (*html.Node, error) {
+	if pupXML {
+		return parseXML(r, cs, declared)
+	}
+	r, info, err := decodeHTML(r, cs, declared)
+	if err != nil {
+		return nil, err
+	}
+	// keep the input to find the positions of start tags in
+	var content bytes.Buffer
+	if trackPositions() {
+		r = io.TeeReader(r, &content)
+	}
+	var root *html.Node
+	if pupFragment != "" {
+		root, err = parseFragment(r, pupFragment)
 	} else {
-		// let the user specify the charset
-		e, name := charset.Lookup(cs)
-		if name == "" {
-			return nil, fmt.Errorf("'%s' is not a valid charset", cs)
-		}
+		root, err = html.Parse(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	setDocumentCharset(root, info)
+	if trackPositions() {
+		setDocumentPositions(root, parsePositions(root, content.Bytes()))
+	}
+	return root, nil
+}
+
+// Convert decompressed html to UTF-8
+func decodeHTML(r io.Reader, cs, declared string) (io.Reader, charsetInfo, error) {
+	// the encoding is determined from the first 1024 bytes, as browsers do
+	preview := make([]byte, 1024)
+	n, err := io.ReadFull(r, preview)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, charsetInfo{}, err
+	}
+	preview = preview[:n]
+	return decodePreviewed(io.MultiReader(bytes.NewReader(preview), r), preview, cs, declared)
+}
+
+// Decode HTML with the charset determined from preview, the start of r
+func decodePreviewed(r io.Reader, preview []byte, cs, declared string) (io.Reader, charsetInfo, error) {
+	e, info, err := determineCharset(preview, cs, declared)
+	if err != nil {
+		return nil, info, err
+	}
+	if e != encoding.Nop {
 		r = transform.NewReader(r, e.NewDecoder())
 	}
-	return html.Parse(r)
+	return r, info, nil
 }
 
 func PrintHelp(w io.Writer, exitCode int) {
 	helpString := `Usage
-    pup [flags] [selectors] [optional display function]
+    pup [flags] [selectors] [optional display function] [files]
+    pup sanitize POLICY [flags] [selectors] [optional display function] [files]
+    pup [flags] [selectors] [optional display function] -- [files]
 Version
     %s
 Flags
     -c --color         print result with color
-    -f --file          file to read from
+    -f --file          file or glob to read from, may be repeated
+    -r --recursive     read the HTML files in a directory and below it
+    -H --with-filename prefix results with the file they came from
+    --with-positions   print the line and column of each element's start tag
+    -j --jobs          number of files to process at once (0 for one per CPU)
     -h --help          display this help
     -i --indent        number of spaces to use for indent or character
     -n --number        print number of elements selected
     -l --limit         restrict number of levels printed
     -p --plain         don't escape html
+    --minify           print HTML without the whitespace, comments, end tags
+                       and quotes it doesn't need, instead of indenting it
     --pre              preserve preformatted text
+    --fragment[=tag]   parse the input as a fragment within a context element
+                       such as tbody, ul or select (default body)
+    --delete SEL       remove the selected nodes, printing the edited document
+    --unwrap SEL       replace the selected nodes with their children
+    --set-attr SEL name=value
+                       set an attribute on the selected nodes
+    --remove-attr SEL name
+                       remove an attribute from the selected nodes
+    --replace SEL HTML replace the selected nodes with some HTML
+    --wrap SEL HTML    wrap the selected nodes in an element
+    --set-text SEL TEXT
+                       replace the contents of the selected nodes with text
+    --in-place[=SUFFIX]
+                       write edited documents back to their files, keeping
+                       the originals with SUFFIX added if it's given
+    --diff             print a diff of the edits to each file instead
+    --lint[=json]      report the markup errors the parser recovers from instead
+                       of selecting nodes, exiting with 1 if any are found
+    --xml              parse the input as XML, e.g. feeds, sitemaps or SVG, and
+                       print XML
+    --stream           display nodes as the input is read instead of parsing it
+                       first, for selectors without pseudo classes or '+'
+    --warc             read the HTML responses of a WARC file, may be repeated
+    --warc-url         only read WARC responses whose URL matches this regexp
+    --mhtml-locations  resolve cid: and relative URLs in MHTML input against the
+                       locations the page was saved from
     --charset          specify the charset for pup to use
+    --charset-fallback charset to use when none is declared or detected
+                       (default windows-1252)
+    --show-charset     print the charset of each document and how it was chosen
+    --decompress       input compression: gzip, bzip2, zstd, brotli or none
+                       (detected by default)
+    --template-file    execute a text/template file for each selected node
+    --width            wrap render{} output at this many columns (0 for none)
+    --missing          print this in attr{} for nodes missing an attribute
+    --base-url         resolve relative URLs in attributes against this URL
+    --files-with-matches
+                       only print the names of files with selected nodes
     --version          display version
 `
 	fmt.Fprintf(w, helpString, VERSION)
@@ -65,10 +191,60 @@ Flags
 }
 
 func ParseArgs() ([]string, error) {
-	cmds, err := ProcessFlags(os.Args[1:])
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "sanitize" {
+		if len(args) < 2 {
+			return []string{}, fmt.Errorf("sanitize needs a policy file")
+		}
+		policy, err := loadSanitizePolicy(args[1])
+		if err != nil {
+			return []string{}, err
+		}
+		pupSanitize = policy
+		args = args[2:]
+	}
+	cmds, err := ProcessFlags(args)
+	if err != nil {
+		return []string{}, err
+	}
+	if pupLint != "" && pupXML {
+		return []string{}, fmt.Errorf("--lint checks HTML and can't be used with --xml")
+	}
+	if pupMinify && pupXML {
+		return []string{}, fmt.Errorf("--minify writes HTML and can't be used with --xml")
+	}
+	if pupSanitize != nil {
+		switch {
+		case pupXML:
+			return []string{}, fmt.Errorf("sanitize cleans HTML and can't be used with --xml")
+		case pupLint != "":
+			return []string{}, fmt.Errorf("sanitize can't be used with --lint")
+		case pupInPlace:
+			return []string{}, fmt.Errorf("sanitize can't be used with --in-place")
+		case !pupEscapeHTML:
+			return []string{}, fmt.Errorf("sanitize always escapes its output and can't be used with --plain")
+		}
+	}
+	cmds, files, err := trailingInputs(cmds)
 	if err != nil {
 		return []string{}, err
 	}
+	pupInputs = append(pupInputs, files...)
+	if pupInPlace {
+		switch {
+		case len(pupEdits) == 0:
+			return []string{}, fmt.Errorf("--in-place needs an edit flag such as --delete")
+		case len(pupInputs) == 0:
+			return []string{}, fmt.Errorf("--in-place needs files to edit")
+		case len(pupWarcs) > 0:
+			return []string{}, fmt.Errorf("WARC files can't be edited in place")
+		case len(cmds) > 0:
+			return []string{}, fmt.Errorf("--in-place can't be used with selectors or display functions")
+		}
+	}
+	if len(pupInputs) > 1 {
+		pupWithFilename = true
+	}
 	return ParseCommands(strings.Join(cmds, " "))
 }
 
@@ -92,13 +268,39 @@ func ProcessFlags(cmds []string) (nonFlagCmds []string, err error) {
 		case "--pre":
 			pupPreformatted = true
 		case "-f", "--file":
-			filename := cmds[i+1]
-			pupIn, err = os.Open(filename)
+			files, err := expandGlob(cmds[i+1])
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-				os.Exit(2)
+				return []string{}, err
 			}
+			pupInputs = append(pupInputs, files...)
 			i++
+		case "--":
+			// everything after -- is a file, whatever it's named
+			pupInputs = append(pupInputs, cmds[i+1:]...)
+			i = len(cmds)
+		case "-r", "--recursive":
+			files, err := walkInputs(cmds[i+1])
+			if err != nil {
+				return []string{}, err
+			}
+			pupInputs = append(pupInputs, files...)
+			pupWithFilename = true
+			i++
+		case "-H", "--with-filename":
+			pupWithFilename = true
+		case "-j", "--jobs":
+			pupJobs, err = strconv.Atoi(cmds[i+1])
+			if err != nil || pupJobs < 0 {
+				return []string{}, fmt.Errorf("Argument for '%s' must be a non-negative number", cmd)
+			}
+			if pupJobs == 0 {
+				pupJobs = runtime.NumCPU()
+			}
+			i++
+		case "--with-positions":
+			pupWithPositions = true
+		case "--files-with-matches":
+			pupFilesWithMatches = true
 		case "-h", "--help":
 			PrintHelp(os.Stdout, 0)
 		case "-i", "--indent":
@@ -115,15 +317,114 @@ func ProcessFlags(cmds []string) (nonFlagCmds []string, err error) {
 				return []string{}, fmt.Errorf("Argument for '%s' must be numeric", cmd)
 			}
 			i++
+		case "--warc":
+			pupWarcs = append(pupWarcs, cmds[i+1])
+			pupWithFilename = true
+			i++
+		case "--warc-url":
+			pupWarcURL, err = regexp.Compile(cmds[i+1])
+			if err != nil {
+				return []string{}, fmt.Errorf("Invalid URL pattern: %s", err.Error())
+			}
+			i++
+		case "--in-place":
+			pupInPlace = true
+		case "--diff":
+			pupInPlace = true
+			pupDiff = true
+		case "--lint":
+			pupLint = "text"
+		case "--xml":
+			pupXML = true
+		case "--minify":
+			pupMinify = true
+		case "--stream":
+			pupStream = true
+		case "--mhtml-locations":
+			pupMHTMLLocations = true
+		case "--decompress":
+			pupDecompress = cmds[i+1]
+			if !validCompression(pupDecompress) {
+				return []string{}, fmt.Errorf("Unknown compression format '%s'", pupDecompress)
+			}
+			i++
 		case "--charset":
 			pupCharset = cmds[i+1]
 			i++
+		case "--charset-fallback":
+			pupCharsetFallback = cmds[i+1]
+			if _, name := charset.Lookup(pupCharsetFallback); name == "" {
+				return []string{}, fmt.Errorf("'%s' is not a valid charset", pupCharsetFallback)
+			}
+			i++
+		case "--show-charset":
+			pupShowCharset = true
+		case "--width":
+			pupWidth, err = strconv.Atoi(cmds[i+1])
+			if err != nil {
+				return []string{}, fmt.Errorf("Argument for '%s' must be numeric", cmd)
+			}
+			i++
+		case "--missing":
+			pupPrintMissing = true
+			pupMissing = cmds[i+1]
+			i++
+		case "--base-url":
+			pupBaseURL, err = url.Parse(cmds[i+1])
+			if err != nil {
+				return []string{}, fmt.Errorf("Invalid base URL: %s", err.Error())
+			}
+			i++
+		case "--template-file":
+			text, err := ioutil.ReadFile(cmds[i+1])
+			if err != nil {
+				return []string{}, err
+			}
+			pupDisplayer, err = ParseTemplate(string(text))
+			if err != nil {
+				return []string{}, err
+			}
+			i++
 		case "--version":
 			fmt.Println(VERSION)
 			os.Exit(0)
 		case "-n", "--number":
 			pupDisplayer = NumDisplayer{}
+		case "--fragment":
+			pupFragment = "body"
 		default:
+			if n, ok := editFlags[cmd]; ok {
+				arg := ""
+				if n == 2 {
+					arg = cmds[i+2]
+				}
+				edit, err := parseEdit(cmd, cmds[i+1], arg)
+				if err != nil {
+					return []string{}, err
+				}
+				pupEdits = append(pupEdits, edit)
+				i += n
+				continue
+			}
+			if strings.HasPrefix(cmd, "--in-place=") {
+				pupInPlace = true
+				pupBackupSuffix = strings.TrimPrefix(cmd, "--in-place=")
+				continue
+			}
+			if strings.HasPrefix(cmd, "--lint=") {
+				pupLint = strings.TrimPrefix(cmd, "--lint=")
+				if pupLint != "text" && pupLint != "json" {
+					return []string{}, fmt.Errorf("Unknown lint format '%s'", pupLint)
+				}
+				continue
+			}
+			if strings.HasPrefix(cmd, "--fragment=") {
+				pupFragment, err = parseFragmentContext(strings.TrimPrefix(cmd, "--fragment="))
+				if err != nil {
+					return []string{}, err
+				}
+				continue
+			}
 			if cmd[0] == '-' {
 				return []string{}, fmt.Errorf("Unrecognized flag '%s'", cmd)
 			}
@@ -160,6 +461,35 @@ func ParseCommands(cmdString string) ([]string, error) {
 			}
 			cmds = append(cmds, ",")
 			last = next + 1
+		case '{':
+			// for braces, consume runes until the matching brace has closed
+			// so display functions such as tmpl{...} stay one command
+			depth := 1
+			var quoteChar byte
+			for depth > 0 {
+				next++
+				if next == max {
+					return []string{}, fmt.Errorf("Unmatched open brace ({)")
+				}
+				switch c := cmdString[next]; {
+				case c == '\\':
+					next++
+					if next == max {
+						return []string{}, fmt.Errorf("Unmatched open brace ({)")
+					}
+				case quoteChar != 0:
+					// braces within quotes don't count
+					if c == quoteChar {
+						quoteChar = 0
+					}
+				case c == '"' || c == '`':
+					quoteChar = c
+				case c == '{':
+					depth++
+				case c == '}':
+					depth--
+				}
+			}
 		case '\'', '"':
 			// for quotes, consume runes until the quote has ended
 			quoteChar := c
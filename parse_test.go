@@ -59,6 +59,13 @@ var parseCmdTests = []parseCmdTest{
 	parseCmdTest{`h1 , .article-teaser , .article-content`, []string{
 		`h1`, `,`, `.article-teaser`, `,`, `.article-content`,
 	}, true},
+	parseCmdTest{`a attr{href}`, []string{`a`, `attr{href}`}, true},
+	parseCmdTest{`a tmpl{{{ attr "href" }}, {{ text }}}`, []string{
+		`a`, `tmpl{{{ attr "href" }}, {{ text }}}`,
+	}, true},
+	parseCmdTest{`a tmpl{{{ "}" }}}`, []string{`a`, `tmpl{{{ "}" }}}`}, true},
+	parseCmdTest{`a tmpl{{{ "{" }}}`, []string{`a`, `tmpl{{{ "{" }}}`}, true},
+	parseCmdTest{`a tmpl{{{ text }}`, []string{}, false},
 }
 
 func sliceEq(s1, s2 []string) bool {
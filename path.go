@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Print a selector for each selected node: a short CSS selector which pup
+// selects only that node with, or with XPath set an absolute XPath.
+type PathDisplayer struct {
+	XPath bool
+}
+
+func (p PathDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	indexes := map[*html.Node]*pathIndex{}
+	for _, node := range nodes {
+		if node.Type != html.ElementNode {
+			continue
+		}
+		if p.XPath {
+			fmt.Fprintln(w, xpath(node))
+			continue
+		}
+		root := node
+		for root.Parent != nil {
+			root = root.Parent
+		}
+		if indexes[root] == nil {
+			indexes[root] = newPathIndex(root)
+		}
+		path, ok := indexes[root].cssPath(node)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Warning: '%s' selects more than one node\n", path)
+		}
+		fmt.Fprintln(w, path)
+	}
+}
+
+var (
+	// names which can be written in a selector without escaping
+	pathNameRe = regexp.MustCompile(`^-?[A-Za-z_][A-Za-z0-9_-]*$`)
+	pathTagRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*(\|[A-Za-z_][A-Za-z0-9_-]*)?$`)
+)
+
+// Finds the nodes the selectors tried by cssPath select without searching
+// the whole document for each one. Elements are indexed by tag and id to
+// rule out most selectors, and the node each selector selects is kept, as
+// many nodes try the same ones.
+type pathIndex struct {
+	root     *html.Node
+	byTag    map[string][]*html.Node
+	byID     map[string][]*html.Node
+	elements []*html.Node
+	// the node each path selects, if it selects only one, and the nodes the
+	// first step of a path selects from the document
+	selected map[string]*html.Node
+	first    map[string][]*html.Node
+	// parsed selectors and selector functions, and the step each element's
+	// siblings don't match
+	selectors map[string]CSSSelector
+	funcs     map[string][]SelectorFunc
+	steps     map[*html.Node]string
+}
+
+func newPathIndex(root *html.Node) *pathIndex {
+	p := &pathIndex{
+		root:      root,
+		byTag:     map[string][]*html.Node{},
+		byID:      map[string][]*html.Node{},
+		selected:  map[string]*html.Node{},
+		first:     map[string][]*html.Node{},
+		selectors: map[string]CSSSelector{},
+		funcs:     map[string][]SelectorFunc{},
+		steps:     map[*html.Node]string{},
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			p.elements = append(p.elements, n)
+			p.byTag[n.Data] = append(p.byTag[n.Data], n)
+			if id, ok := getAttr(n, "id"); ok {
+				p.byID[id] = append(p.byID[id], n)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return p
+}
+
+// Build the shortest chain of child selectors, ending at a node, which
+// selects only that node. Each step prefers an id, then the tag, a class and
+// finally the position of the node among the siblings of its type. ok is
+// false if the whole chain still selects other nodes, as it may for the
+// nodes of a fragment.
+func (p *pathIndex) cssPath(n *html.Node) (path string, ok bool) {
+	steps := []string{}
+	for c := n; c != nil && c.Type == html.ElementNode; c = c.Parent {
+		for _, step := range pathSteps(c) {
+			path := strings.Join(append([]string{step}, steps...), " > ")
+			if p.selectsOnly(path, n) {
+				return path, true
+			}
+		}
+		steps = append([]string{p.siblingStep(c)}, steps...)
+	}
+	path = strings.Join(steps, " > ")
+	return path, p.selectsOnly(path, n)
+}
+
+// The selectors which match an element, from the most to the least general
+func pathSteps(n *html.Node) []string {
+	steps := []string{}
+	if id, ok := getAttr(n, "id"); ok && pathNameRe.MatchString(id) {
+		steps = append(steps, "#"+id)
+	}
+	tag := strings.Replace(n.Data, ":", "|", 1)
+	if !pathTagRe.MatchString(tag) {
+		return append(steps, fmt.Sprintf(":nth-child(%d)", childIndex(n)))
+	}
+	steps = append(steps, tag)
+	classes := pathClasses(n)
+	for _, class := range classes {
+		steps = append(steps, tag+"."+class)
+	}
+	if len(classes) > 1 {
+		steps = append(steps, tag+"."+strings.Join(classes, "."))
+	}
+	return append(steps, fmt.Sprintf("%s:nth-of-type(%d)", tag, typeIndex(n)))
+}
+
+// The least general selector which matches an element and none of its
+// siblings
+func (p *pathIndex) siblingStep(n *html.Node) string {
+	if step, ok := p.steps[n]; ok {
+		return step
+	}
+	steps := pathSteps(n)
+	step := steps[len(steps)-1]
+	if n.Parent != nil {
+		for _, s := range steps {
+			if strings.HasPrefix(s, "#") {
+				continue
+			}
+			selector, err := p.parse(s)
+			unique := err == nil
+			for c := n.Parent.FirstChild; c != nil && unique; c = c.NextSibling {
+				unique = c == n || !selector.Match(c)
+			}
+			if unique {
+				step = s
+				break
+			}
+		}
+	}
+	p.steps[n] = step
+	return step
+}
+
+// Parse a selector once, as parsing compiles regular expressions
+func (p *pathIndex) parse(step string) (CSSSelector, error) {
+	if selector, ok := p.selectors[step]; ok {
+		return selector, nil
+	}
+	selector, err := ParseSelector(step)
+	if err == nil {
+		p.selectors[step] = selector
+	}
+	return selector, err
+}
+
+// The classes of an element which can be written in a selector
+func pathClasses(n *html.Node) []string {
+	classes := []string{}
+	class, _ := getAttr(n, "class")
+	for _, c := range strings.Fields(class) {
+		if pathNameRe.MatchString(c) {
+			classes = append(classes, c)
+		}
+	}
+	return classes
+}
+
+// Does a selector select exactly one node, n, from the document?
+func (p *pathIndex) selectsOnly(path string, n *html.Node) bool {
+	only, ok := p.selected[path]
+	if !ok {
+		// the index finds the node a path could select, and the selector
+		// functions pup queries with have the last word
+		if only = p.selectsOne(path); only != nil && !p.queriesOne(path, only) {
+			only = nil
+		}
+		p.selected[path] = only
+	}
+	return only == n
+}
+
+// Is n the only node the selector functions for a path select? As with
+// SelectNodes each function is applied to the nodes the one before it
+// selected, but the first step's nodes are found once for all the paths
+// starting with it.
+func (p *pathIndex) queriesOne(path string, n *html.Node) bool {
+	cmds, err := ParseCommands(path)
+	// steps alternating with child combinators
+	if err != nil || len(cmds)%2 == 0 {
+		return false
+	}
+	nodes, ok := p.first[cmds[0]]
+	if !ok {
+		selectorFuncs, err := p.selectorFuncs(cmds[:1])
+		if err != nil {
+			return false
+		}
+		nodes = SelectNodes(p.root, selectorFuncs)
+		p.first[cmds[0]] = nodes
+	}
+	for i := 1; i < len(cmds); i += 2 {
+		selectorFuncs, err := p.selectorFuncs(cmds[i : i+2])
+		if err != nil {
+			return false
+		}
+		for _, selectorFunc := range selectorFuncs {
+			nodes = selectorFunc(nodes)
+		}
+	}
+	return len(nodes) == 1 && nodes[0] == n
+}
+
+// Parse the selector functions for a step of a path once, as parsing
+// compiles regular expressions
+func (p *pathIndex) selectorFuncs(cmds []string) ([]SelectorFunc, error) {
+	key := strings.Join(cmds, " ")
+	if selectorFuncs, ok := p.funcs[key]; ok {
+		return selectorFuncs, nil
+	}
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err == nil {
+		p.funcs[key] = selectorFuncs
+	}
+	return selectorFuncs, err
+}
+
+// The node a chain of child selectors selects if it selects exactly one, or
+// nil, as far as the index can tell. As with SelectNodes the first step selects the elements it matches
+// which aren't within another it matches, and each step after it their
+// children which it matches. The chain is followed down from the first step
+// or up from the last, whichever matches fewer elements, until a second node
+// is found.
+func (p *pathIndex) selectsOne(path string) *html.Node {
+	steps := strings.Split(path, " > ")
+	selectors := make([]CSSSelector, len(steps))
+	for i, step := range steps {
+		selector, err := p.parse(step)
+		if err != nil {
+			return nil
+		}
+		selectors[i] = selector
+	}
+	first := p.candidates(steps[0], selectors[0])
+	last := p.candidates(steps[len(steps)-1], selectors[len(steps)-1])
+	var only *html.Node
+	if len(first) <= len(last) {
+		var down func(n *html.Node, i int) bool
+		down = func(n *html.Node, i int) bool {
+			if i == len(selectors) {
+				if only != nil {
+					return false
+				}
+				only = n
+				return true
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if selectors[i].Match(c) && !down(c, i+1) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, n := range first {
+			if selectsFirst(selectors[0], n) && !down(n, 1) {
+				return nil
+			}
+		}
+		return only
+	}
+	for _, n := range last {
+		top := n
+		for i := len(selectors) - 1; top != nil && i > 0; i-- {
+			if !selectors[i].Match(top) {
+				top = nil
+			} else {
+				top = top.Parent
+			}
+		}
+		if top != nil && selectsFirst(selectors[0], top) {
+			if only != nil {
+				return nil
+			}
+			only = n
+		}
+	}
+	return only
+}
+
+// The elements a step of a path could match
+func (p *pathIndex) candidates(step string, selector CSSSelector) []*html.Node {
+	switch {
+	case strings.HasPrefix(step, "#"):
+		return p.byID[strings.TrimPrefix(step, "#")]
+	case selector.Tag != "":
+		return p.byTag[selector.Tag]
+	}
+	return p.elements
+}
+
+// Does the first step of a selector select an element? Select doesn't look
+// within the elements it matches.
+func selectsFirst(selector CSSSelector, n *html.Node) bool {
+	if !selector.Match(n) {
+		return false
+	}
+	for a := n.Parent; a != nil; a = a.Parent {
+		if selector.Match(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// The position of an element among its element siblings, from 1
+func childIndex(n *html.Node) int {
+	i := 1
+	for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+		if c.Type == html.ElementNode {
+			i++
+		}
+	}
+	return i
+}
+
+// The position of an element among its siblings with the same name, from 1
+func typeIndex(n *html.Node) int {
+	i := 1
+	for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+		if c.Type == html.ElementNode && c.Data == n.Data {
+			i++
+		}
+	}
+	return i
+}
+
+// The absolute XPath of an element, e.g. /html/body/div[2]/p. Positions are
+// only given among siblings with the same name.
+func xpath(n *html.Node) string {
+	steps := []string{}
+	for c := n; c != nil && c.Type == html.ElementNode; c = c.Parent {
+		step := c.Data
+		shared := typeIndex(c) > 1
+		for s := c.NextSibling; s != nil && !shared; s = s.NextSibling {
+			shared = s.Type == html.ElementNode && s.Data == c.Data
+		}
+		if shared {
+			step = fmt.Sprintf("%s[%d]", step, typeIndex(c))
+		}
+		steps = append([]string{step}, steps...)
+	}
+	return "/" + strings.Join(steps, "/")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const pathTestHTML = `<html><body>
+<div id="nav"><ul><li><a href="/">Home</a></li><li class="x"><a href="/a">A</a></li></ul></div>
+<div class="post x"><p>one</p><p class="lead">two</p><div class="post"><p>nested</p></div></div>
+<div id="dup"></div><div id="dup"><p>three</p></div>
+<section><my:tag>?</my:tag></section>
+</body></html>`
+
+func TestCSSPath(t *testing.T) {
+	root, err := html.Parse(strings.NewReader(pathTestHTML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every element should have a selector which selects only it
+	index := newPathIndex(root)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if path, ok := index.cssPath(n); !ok || !selectsOnlyNode(t, root, path, n) {
+				t.Errorf("%s: '%s' doesn't select only that node", xpath(n), path)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	tests := []struct {
+		query, path, xpath string
+	}{
+		{"#nav li.x a", "li.x > a", "/html/body/div[1]/ul/li[2]/a"},
+		{"p.lead", "p.lead", "/html/body/div[2]/p[2]"},
+		{"div.post div.post p", "div:nth-of-type(1) > p", "/html/body/div[2]/div/p"},
+		{"div:nth-of-type(4) p", "#dup > p", "/html/body/div[4]/p"},
+	}
+	for _, test := range tests {
+		cmds, _ := ParseCommands(test.query)
+		selectorFuncs, err := ParseSelectorFuncs(cmds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes := SelectNodes(root, selectorFuncs)
+		if len(nodes) != 1 {
+			t.Fatalf("%s: expected 1 node got %d", test.query, len(nodes))
+		}
+		if path, _ := index.cssPath(nodes[0]); path != test.path {
+			t.Errorf("%s: expected path '%s' got '%s'", test.query, test.path, path)
+		}
+		if path := xpath(nodes[0]); path != test.xpath {
+			t.Errorf("%s: expected XPath '%s' got '%s'", test.query, test.xpath, path)
+		}
+	}
+}
+
+// Does pup select only n with a path, as found by querying the document?
+func selectsOnlyNode(t *testing.T, root *html.Node, path string, n *html.Node) bool {
+	cmds, err := ParseCommands(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := SelectNodes(root, selectorFuncs)
+	return len(nodes) == 1 && nodes[0] == n
+}
+
+// Finding the path of every element of a large page mustn't query the whole
+// page for each path tried, or parse a selector for each sibling it's
+// checked against. The time is measured against parsing the page, so the
+// limit holds on slow machines and with -race: finding the paths takes about
+// as long as 50 parses, and took over 500 parsing each selector.
+func TestCSSPathPage(t *testing.T) {
+	page, err := ioutil.ReadFile("tests/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	root, err := html.Parse(strings.NewReader(string(page)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsing := time.Since(start)
+	nodes := []*html.Node{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			nodes = append(nodes, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	start = time.Now()
+	index := newPathIndex(root)
+	paths := make([]string, len(nodes))
+	for i, n := range nodes {
+		var ok bool
+		if paths[i], ok = index.cssPath(n); !ok {
+			t.Errorf("%s: '%s' doesn't select only that node", xpath(n), paths[i])
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 200*parsing {
+		t.Errorf("finding the paths of %d elements took %s, parsing the page %s", len(nodes), elapsed, parsing)
+	}
+	// check some of the paths against the document
+	for i := 0; i < len(nodes); i += 97 {
+		if !selectsOnlyNode(t, root, paths[i], nodes[i]) {
+			t.Errorf("%s: '%s' doesn't select only that node", xpath(nodes[i]), paths[i])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A position in the decoded input. Lines and columns start at 1 and columns
+// count characters rather than bytes.
+type sourcePos struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Move a position past some input
+func (p *sourcePos) advance(raw []byte) {
+	p.Offset += len(raw)
+	for len(raw) > 0 {
+		r, size := utf8.DecodeRune(raw)
+		raw = raw[size:]
+		switch r {
+		case '\n':
+			p.Line++
+			p.Column = 1
+		case '\r':
+		default:
+			p.Column++
+		}
+	}
+}
+
+var startPos = sourcePos{Line: 1, Column: 1}
+
+var (
+	nodePositionsMu sync.Mutex
+	nodePositions   = map[*html.Node]map[*html.Node]sourcePos{}
+)
+
+// Are the positions of start tags needed?
+func trackPositions() bool {
+	_, loc := pupDisplayer.(LocDisplayer)
+	return pupWithPositions || loc
+}
+
+func setDocumentPositions(root *html.Node, positions map[*html.Node]sourcePos) {
+	nodePositionsMu.Lock()
+	defer nodePositionsMu.Unlock()
+	nodePositions[root] = positions
+}
+
+// The position of an element's start tag. Elements the parser implied or
+// copied have none.
+func nodePosition(n *html.Node) (sourcePos, bool) {
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	nodePositionsMu.Lock()
+	defer nodePositionsMu.Unlock()
+	pos, ok := nodePositions[root][n]
+	return pos, ok
+}
+
+// Elements the parser inserts without a start tag
+var impliedElements = []atom.Atom{
+	atom.Html, atom.Head, atom.Body, atom.Tbody, atom.Tr, atom.Colgroup,
+}
+
+// Find the start tag of each element parsed from content by tokenizing it
+// again. Tags are matched to elements in document order, skipping elements
+// which were implied or reopened as formatting elements and tags the parser
+// dropped, so a position may be missing but shouldn't be wrong.
+func parsePositions(root *html.Node, content []byte) map[*html.Node]sourcePos {
+	type startTag struct {
+		name string
+		pos  sourcePos
+	}
+	tags := []startTag{}
+	pos := startPos
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			name, _ := z.TagName()
+			tags = append(tags, startTag{string(name), pos})
+		}
+		pos.advance(z.Raw())
+	}
+
+	positions := map[*html.Node]sourcePos{}
+	next := 0
+	var match func(n *html.Node)
+	match = func(n *html.Node) {
+		if n.Type == html.ElementNode && next < len(tags) {
+			if strings.EqualFold(tags[next].name, n.Data) {
+				positions[n] = tags[next].pos
+				next++
+			} else if !hasAtom(impliedElements, n.DataAtom) && !hasAtom(formattingElements, n.DataAtom) {
+				// look past a few tags the parser may have dropped
+				for i := next + 1; i < len(tags) && i <= next+16; i++ {
+					if strings.EqualFold(tags[i].name, n.Data) {
+						positions[n] = tags[i].pos
+						next = i + 1
+						break
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			match(c)
+		}
+	}
+	match(root)
+	return positions
+}
+
+// Print the position of each node as file:line:column, for editors. Nodes
+// without a start tag use the position of the nearest element with one.
+type LocDisplayer struct{}
+
+func (l LocDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	for _, node := range nodes {
+		pos := startPos
+		for n := node; n != nil; n = n.Parent {
+			if p, ok := nodePosition(n); ok {
+				pos = p
+				break
+			}
+		}
+		fmt.Fprintf(w, "%s:%d:%d\n", lookupSource(node).name(), pos.Line, pos.Column)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePositions(t *testing.T) {
+	pupWithPositions = true
+	defer func() { pupWithPositions = false }()
+
+	input := "<b>1<p>x</b>y</p>\n<table><tr><td>é</td><td><i>2</i></table>"
+	root, err := ParseHTML(strings.NewReader(input), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseDocument(root)
+
+	tests := []struct {
+		query  string
+		pos    sourcePos
+		hasPos bool
+	}{
+		{"body > b", sourcePos{0, 1, 1}, true},
+		{"p", sourcePos{4, 1, 5}, true},
+		// reopened by the parser within the <p>
+		{"p b", sourcePos{}, false},
+		{"tbody", sourcePos{}, false},
+		{"tr", sourcePos{25, 2, 8}, true},
+		{"i", sourcePos{44, 2, 26}, true},
+	}
+	for _, test := range tests {
+		cmds, _ := ParseCommands(test.query)
+		selectorFuncs, err := ParseSelectorFuncs(cmds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes := SelectNodes(root, selectorFuncs)
+		if len(nodes) != 1 {
+			t.Fatalf("%s: expected 1 node got %d", test.query, len(nodes))
+		}
+		pos, ok := nodePosition(nodes[0])
+		if ok != test.hasPos || pos != test.pos {
+			t.Errorf("%s: expected %v %v got %v %v", test.query, test.pos, test.hasPos, pos, ok)
+		}
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"os"
 
-	"golang.org/x/net/html"
+	colorable "github.com/mattn/go-colorable"
 )
 
 //      _=,_
@@ -26,55 +26,74 @@ func main() {
 		os.Exit(2)
 	}
 
-	// Parse the input and get the root node
-	root, err := ParseHTML(pupIn, pupCharset)
+	// Parse the display function, which may only be the last command
+	if len(cmds) > 0 {
+		err := ParseDisplayer(cmds[len(cmds)-1])
+		if err == nil {
+			cmds = cmds[:len(cmds)-1]
+		} else if err != errUnknownDisplayer {
+			fmt.Fprintf(os.Stderr, "Display function error: %s\n", err.Error())
+			os.Exit(2)
+		}
+	}
+
+	// Edited documents are printed as they're serialized unless another
+	// display function was given
+	if _, ok := pupDisplayer.(TreeDisplayer); ok && len(pupEdits) > 0 {
+		pupDisplayer = HTMLDisplayer{Exact: true}
+	}
+
+	// Sanitized documents must stay escaped however they're displayed
+	if pupSanitize != nil {
+		if pupDisplayer, err = sanitizedDisplayer(pupDisplayer); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(2)
+		}
+	}
+
+	// Minify the HTML printed by default or by html{} and inner{}
+	if pupMinify {
+		switch d := pupDisplayer.(type) {
+		case TreeDisplayer:
+			pupDisplayer = HTMLDisplayer{Minify: true, Exact: true, Shorten: true}
+		case HTMLDisplayer:
+			d.Minify, d.Exact, d.Shorten = true, true, true
+			pupDisplayer = d
+		}
+	}
+
+	// Parse the selectors
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		fmt.Fprintf(os.Stderr, "Selector parsing error: %s\n", err.Error())
 		os.Exit(2)
 	}
-	pupIn.Close()
 
-	// Parse the selectors
-	selectorFuncs := []SelectorFunc{}
-	funcGenerator := Select
-	var cmd string
-	for len(cmds) > 0 {
-		cmd, cmds = cmds[0], cmds[1:]
-		if len(cmds) == 0 {
-			if err := ParseDisplayer(cmd); err == nil {
-				continue
-			}
-		}
-		switch cmd {
-		case "*": // select all
-			continue
-		case ">":
-			funcGenerator = SelectFromChildren
-		case "+":
-			funcGenerator = SelectNextSibling
-		case ",": // nil will signify a comma
-			selectorFuncs = append(selectorFuncs, nil)
-		default:
-			selector, err := ParseSelector(cmd)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Selector parsing error: %s\n", err.Error())
-				os.Exit(2)
-			}
-			selectorFuncs = append(selectorFuncs, funcGenerator(selector))
-			funcGenerator = Select
+	// Streaming needs a selector which can be decided as elements are read
+	// and a displayer which prints nodes one at a time
+	var stream *streamSelector
+	if pupStream {
+		var ok bool
+		if stream, ok = parseStreamSelector(cmds); !ok || pupXML || len(pupEdits) > 0 || pupSanitize != nil || !isStreamDisplayer(pupDisplayer) {
+			fmt.Fprintf(os.Stderr, "Warning: the query can't be streamed, parsing whole documents\n")
+			stream = nil
 		}
 	}
 
-	selectedNodes := []*html.Node{}
-	currNodes := []*html.Node{root}
-	for _, selectorFunc := range selectorFuncs {
-		if selectorFunc == nil { // hit a comma
-			selectedNodes = append(selectedNodes, currNodes...)
-			currNodes = []*html.Node{root}
-		} else {
-			currNodes = selectorFunc(currNodes)
+	// Query the inputs. A file which can't be read is reported without
+	// stopping the others.
+	out := colorable.NewColorableStdout()
+	failed := false
+	for err := range runJobs(queryJobs(selectorFuncs, stream), pupJobs, out) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			failed = true
 		}
 	}
-	selectedNodes = append(selectedNodes, currNodes...)
-	pupDisplayer.Display(selectedNodes)
+	if failed {
+		os.Exit(2)
+	}
+	if lintProblems > 0 {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Print nodes as readable text, laid out the way a text browser would.
+// Paragraphs are wrapped to `--width`, lists get bullets or numbers, table
+// columns are aligned and links are footnoted.
+type RenderDisplayer struct{}
+
+func (r RenderDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	var out bytes.Buffer
+	t := &textRenderer{
+		out:   &out,
+		width: pupWidth,
+		links: &[]string{},
+		blank: true,
+	}
+	for _, node := range nodes {
+		t.render(node)
+		t.flush()
+	}
+	if len(*t.links) > 0 {
+		t.paragraph()
+		for i, link := range *t.links {
+			fmt.Fprintf(&out, "[%d] %s\n", i+1, link)
+		}
+	}
+	s := strings.TrimRight(out.String(), "\n")
+	if s != "" {
+		fmt.Fprintln(w, s)
+	}
+}
+
+type textRenderer struct {
+	out    *bytes.Buffer
+	width  int
+	indent string
+	// list marker to print in place of the indent on the next line
+	marker string
+	// inline text waiting to be wrapped
+	text  bytes.Buffer
+	links *[]string
+	lists int
+	// was the last line written blank, or has nothing been written yet
+	blank bool
+}
+
+func (t *textRenderer) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		t.text.WriteString(n.Data)
+		return
+	case html.DocumentNode:
+		t.renderChildren(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	if isHiddenElement(n) {
+		return
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		t.flush()
+	case atom.Hr:
+		t.paragraph()
+		t.writeLine(strings.Repeat("-", t.lineWidth()))
+		t.paragraph()
+	case atom.Img:
+		if alt, ok := getAttr(n, "alt"); ok && strings.TrimSpace(alt) != "" {
+			t.text.WriteString("[" + strings.TrimSpace(alt) + "]")
+		}
+	case atom.A:
+		t.renderChildren(n)
+		if href, ok := getAttr(n, "href"); ok {
+			*t.links = append(*t.links, href)
+			fmt.Fprintf(&t.text, "[%d]", len(*t.links))
+		}
+	case atom.Pre:
+		t.paragraph()
+		var buf bytes.Buffer
+		writeRawText(&buf, n)
+		// a newline directly after <pre> is dropped by the parser
+		s := strings.TrimRight(buf.String(), "\n")
+		for _, line := range strings.Split(s, "\n") {
+			t.writeLine(line)
+		}
+		t.paragraph()
+	case atom.Ul, atom.Ol:
+		t.list(n)
+	case atom.Li:
+		t.item(n, "* ")
+	case atom.Table:
+		t.table(n)
+	case atom.H1, atom.H2:
+		t.paragraph()
+		t.renderChildren(n)
+		underline := "="
+		if n.DataAtom == atom.H2 {
+			underline = "-"
+		}
+		if w := t.flush(); w > 0 {
+			t.writeLine(strings.Repeat(underline, w))
+		}
+		t.paragraph()
+	case atom.Blockquote, atom.Dd:
+		t.paragraph()
+		indent := t.indent
+		t.indent += "    "
+		t.renderChildren(n)
+		t.flush()
+		t.indent = indent
+		t.paragraph()
+	case atom.P, atom.H3, atom.H4, atom.H5, atom.H6, atom.Dl, atom.Figure,
+		atom.Address, atom.Fieldset, atom.Form:
+		t.paragraph()
+		t.renderChildren(n)
+		t.paragraph()
+	default:
+		if isBlockElement(n) {
+			t.flush()
+			t.renderChildren(n)
+			t.flush()
+		} else {
+			t.renderChildren(n)
+		}
+	}
+}
+
+func (t *textRenderer) renderChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		t.render(c)
+	}
+}
+
+// Render the items of a <ul> or <ol>
+func (t *textRenderer) list(n *html.Node) {
+	if t.lists == 0 {
+		t.paragraph()
+	} else {
+		t.flush()
+	}
+	t.lists++
+	num := 1
+	start, _ := getAttr(n, "start")
+	if start, err := strconv.Atoi(start); err == nil {
+		num = start
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li || isHiddenElement(c) {
+			t.render(c)
+			continue
+		}
+		marker := "* "
+		if n.DataAtom == atom.Ol {
+			value, _ := getAttr(c, "value")
+			if value, err := strconv.Atoi(value); err == nil {
+				num = value
+			}
+			marker = fmt.Sprintf("%d. ", num)
+			num++
+		}
+		t.item(c, marker)
+	}
+	t.lists--
+	if t.lists == 0 {
+		t.paragraph()
+	}
+}
+
+// Render a list item with the marker in front of its first line
+func (t *textRenderer) item(n *html.Node, marker string) {
+	t.flush()
+	if t.marker != "" {
+		// an item which only holds a nested list still gets its marker
+		t.writeLine("")
+	}
+	indent := t.indent
+	t.indent += strings.Repeat(" ", len(marker))
+	t.marker = marker
+	t.renderChildren(n)
+	t.flush()
+	t.indent, t.marker = indent, ""
+}
+
+// Render a table with its columns aligned
+func (t *textRenderer) table(n *html.Node) {
+	t.paragraph()
+	rows := [][]string{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || isHiddenElement(c) {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Caption:
+				t.text.WriteString(t.inlineText(c))
+				t.flush()
+			case atom.Tr:
+				row := []string{}
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						row = append(row, t.inlineText(cell))
+					}
+				}
+				rows = append(rows, row)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	widths := []int{}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := utf8.RuneCountInString(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	for _, row := range rows {
+		var line bytes.Buffer
+		for i, cell := range row {
+			line.WriteString(cell)
+			pad := widths[i] - utf8.RuneCountInString(cell) + 2
+			line.WriteString(strings.Repeat(" ", pad))
+		}
+		t.writeLine(line.String())
+	}
+	t.paragraph()
+}
+
+// Render the children of a node onto a single line
+func (t *textRenderer) inlineText(n *html.Node) string {
+	sub := &textRenderer{
+		out:   &bytes.Buffer{},
+		links: t.links,
+		blank: true,
+	}
+	sub.renderChildren(n)
+	sub.flush()
+	return strings.Join(strings.Fields(sub.out.String()), " ")
+}
+
+// Wrap and write any pending inline text. Returns the width of the widest
+// line written.
+func (t *textRenderer) flush() int {
+	words := strings.Fields(t.text.String())
+	t.text.Reset()
+	widest := 0
+	var line bytes.Buffer
+	lineWidth := 0
+	writeLine := func() {
+		t.writeLine(line.String())
+		if lineWidth > widest {
+			widest = lineWidth
+		}
+		line.Reset()
+		lineWidth = 0
+	}
+	for _, word := range words {
+		w := utf8.RuneCountInString(word)
+		if lineWidth > 0 {
+			if t.width > 0 && lineWidth+1+w > t.lineWidth() {
+				writeLine()
+			} else {
+				line.WriteByte(' ')
+				lineWidth++
+			}
+		}
+		line.WriteString(word)
+		lineWidth += w
+	}
+	if lineWidth > 0 {
+		writeLine()
+	}
+	return widest
+}
+
+// Ensure a blank line separates what has been written from what follows
+func (t *textRenderer) paragraph() {
+	t.flush()
+	if !t.blank {
+		t.out.WriteString("\n")
+		t.blank = true
+	}
+}
+
+func (t *textRenderer) writeLine(s string) {
+	prefix := t.indent
+	if t.marker != "" {
+		prefix = prefix[:len(prefix)-len(t.marker)] + t.marker
+		t.marker = ""
+	}
+	line := strings.TrimRight(prefix+s, " ")
+	t.out.WriteString(line)
+	t.out.WriteString("\n")
+	t.blank = line == ""
+}
+
+// Room left on a line after indentation
+func (t *textRenderer) lineWidth() int {
+	if w := t.width - len(t.indent); w > 1 {
+		return w
+	}
+	return 1
+}
+
+// Write the text of a node and its children exactly as it appears
+func writeRawText(buf *bytes.Buffer, n *html.Node) {
+	if n.Type == html.TextNode {
+		buf.WriteString(n.Data)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeRawText(buf, c)
+	}
+}
+
+// Does this element start a new line when rendered?
+func isBlockElement(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Address, atom.Article, atom.Aside, atom.Blockquote, atom.Center,
+		atom.Dd, atom.Details, atom.Dialog, atom.Div, atom.Dl, atom.Dt,
+		atom.Fieldset, atom.Figcaption, atom.Figure, atom.Footer, atom.Form,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Header,
+		atom.Hgroup, atom.Hr, atom.Li, atom.Nav, atom.Ol, atom.P, atom.Pre,
+		atom.Section, atom.Summary, atom.Table, atom.Tr, atom.Ul,
+		atom.Body, atom.Html:
+		return true
+	}
+	return n.Data == "main"
+}
+
+// Is this element, and everything in it, invisible to a reader?
+func isHiddenElement(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head, atom.Template, atom.Noscript,
+		atom.Title, atom.Meta, atom.Link:
+		return true
+	}
+	if _, ok := getAttr(n, "hidden"); ok {
+		return true
+	}
+	if typ, _ := getAttr(n, "type"); n.DataAtom == atom.Input && strings.EqualFold(typ, "hidden") {
+		return true
+	}
+	style, _ := getAttr(n, "style")
+	style = strings.ToLower(strings.Replace(style, " ", "", -1))
+	return strings.Contains(style, "display:none") ||
+		strings.Contains(style, "visibility:hidden")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	defer func(width int) { pupWidth = width }(pupWidth)
+	tests := []struct {
+		input    string
+		width    int
+		expected string
+	}{
+		// paragraphs are wrapped
+		{`<p>a  b
+			c</p><p>d</p>`, 80, "a b c\n\nd"},
+		{`<p>aa bb cc dd ee</p>`, 8, "aa bb cc\ndd ee"},
+		{`<p>aaaaaaaaaa bb</p>`, 8, "aaaaaaaaaa\nbb"},
+		{`<p>aa bb cc dd ee</p>`, 0, "aa bb cc dd ee"},
+		{`<div>a</div><div>b<br>c</div>`, 80, "a\nb\nc"},
+		{`<h1>ab cd</h1><h2>e</h2><h3>f</h3>`, 80, "ab cd\n=====\n\ne\n-\n\nf"},
+		{`<blockquote><p>aa bb cc</p></blockquote>`, 10, "    aa bb\n    cc"},
+		{`<p>a<script>b</script><span hidden>c</span><img alt=" d "><img src=e></p>`, 80, "a[d]"},
+		{"<pre>\n  a\n\n    b\n</pre>", 2, "  a\n\n    b"},
+
+		// lists
+		{`<ul><li>a<li>b</ul>`, 80, "* a\n* b"},
+		{`<ol start=9><li>a<li value=20>b<li>c</ol>`, 80, "9. a\n20. b\n21. c"},
+		{`<ul><li>a<ol><li>b<ul><li>c</ul></ol><li>d</ul>`, 80, "* a\n  1. b\n     * c\n* d"},
+		{`<ul><li><ul><li>a</ul></ul>`, 80, "*\n  * a"},
+		{`<ul><li>aa bb cc dd</ul>`, 8, "* aa bb\n  cc dd"},
+		{`<p>a</p><ul><li>b</ul><p>c</p>`, 80, "a\n\n* b\n\nc"},
+
+		// tables
+		{`<table><caption>t</caption><tr><th>a<th>bbb<tr><td>cc<td>d</table>`, 80, "t\na   bbb\ncc  d"},
+		{`<table><tr><td>a <b>b</b><br>c<td>d</tr><tr><td>e</table>`, 80, "a b c  d\ne"},
+
+		// links are footnoted in order
+		{`<p><a href=/a>a</a> <a>b</a> <a href=/c>c</a></p>`, 80, "a[1] b c[2]\n\n[1] /a\n[2] /c"},
+		{`<table><tr><td><a href=/a>a</a></table><p><a href=/b>b</a></p>`, 80, "a[1]\n\nb[2]\n\n[1] /a\n[2] /b"},
+		{`<ul><li><a href=/a>a</a></ul>`, 80, "* a[1]\n\n[1] /a"},
+	}
+	for _, test := range tests {
+		pupWidth = test.width
+		output := displayString(t, RenderDisplayer{}, test.input, "body")
+		if output = strings.TrimSuffix(output, "\n"); output != test.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.input, test.expected, output)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// An allowlist for `pup sanitize`, read from a JSON file:
+//
+//	{
+//	  "elements": ["p", "a", "img", "ul", "li"],
+//	  "attributes": {"a": ["href"], "img": ["src", "alt"], "*": ["title", "data-*"]},
+//	  "url_schemes": ["http", "https", "mailto"],
+//	  "drop": [".signature", "table"]
+//	}
+//
+// Elements which aren't allowed are replaced by their children, except for
+// those in droppedElements which are removed with them. Attributes listed
+// under "*" are allowed on every element, and a name ending in * allows the
+// names starting with it. Nodes the drop selectors select are removed.
+type sanitizePolicy struct {
+	Elements   []string            `json:"elements"`
+	Attributes map[string][]string `json:"attributes"`
+	URLSchemes []string            `json:"url_schemes"`
+	Drop       []string            `json:"drop"`
+
+	elements   map[string]bool
+	attributes map[string][]string
+	schemes    map[string]bool
+	drop       [][]SelectorFunc
+}
+
+// Elements whose content isn't text of the page, such as scripts, embedded
+// documents and SVG, which are removed with their content unless allowed
+var droppedElements = map[string]bool{
+	"applet": true, "embed": true, "frameset": true, "iframe": true,
+	"math": true, "noembed": true, "noframes": true, "noscript": true,
+	"object": true, "plaintext": true, "script": true, "style": true,
+	"svg": true, "template": true, "xmp": true,
+}
+
+// Elements which can run scripts, load other documents, change how the rest
+// of the page is parsed or change where its links go, so a policy can't
+// allow them
+var unsafeElements = map[string]bool{
+	"applet": true, "base": true, "embed": true, "frame": true,
+	"frameset": true, "iframe": true, "link": true, "math": true,
+	"meta": true, "noembed": true, "noframes": true, "noscript": true,
+	"object": true, "plaintext": true, "script": true, "style": true,
+	"svg": true, "template": true, "xmp": true,
+}
+
+// Attributes which hold URLs, besides the linkAttrs pup resolves. ping holds
+// a list of them.
+var sanitizeURLAttrs = map[string]bool{
+	"archive": true, "background": true, "codebase": true, "dynsrc": true,
+	"formaction": true, "icon": true, "longdesc": true, "lowsrc": true,
+	"manifest": true, "ping": true, "profile": true, "usemap": true,
+	"xlink:href": true,
+}
+
+// Schemes which run scripts, so a policy can't allow them
+var unsafeSchemes = map[string]bool{
+	"javascript": true, "livescript": true, "vbscript": true,
+}
+
+// The displayer to print sanitized documents with. Text which was escaped in
+// the input must stay escaped, so HTML is always serialized escaping what the
+// serialization algorithm does, and displayers which print text or attribute
+// values as they are aren't allowed.
+func sanitizedDisplayer(d Displayer) (Displayer, error) {
+	switch d := d.(type) {
+	case TreeDisplayer:
+		return HTMLDisplayer{Exact: true}, nil
+	case HTMLDisplayer:
+		d.Exact = true
+		return d, nil
+	case TextDisplayer, AttrDisplayer, RenderDisplayer, TemplateDisplayer:
+		return nil, fmt.Errorf("sanitize prints HTML and can't be used with a display function which doesn't escape text")
+	}
+	return d, nil
+}
+
+// Read a policy file and check it allows nothing unsafe
+func loadSanitizePolicy(name string) (*sanitizePolicy, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	p := &sanitizePolicy{}
+	if err := d.Decode(p); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return p, nil
+}
+
+func (p *sanitizePolicy) compile() error {
+	p.elements = map[string]bool{}
+	for _, e := range p.Elements {
+		e = strings.ToLower(e)
+		if unsafeElements[e] {
+			return fmt.Errorf("element <%s> can't be allowed", e)
+		}
+		p.elements[e] = true
+	}
+	p.attributes = map[string][]string{}
+	for e, attrs := range p.Attributes {
+		e = strings.ToLower(e)
+		for _, a := range attrs {
+			a = strings.ToLower(a)
+			if strings.HasPrefix(a, "on") || a == "*" {
+				return fmt.Errorf("attribute '%s' can't be allowed", a)
+			}
+			p.attributes[e] = append(p.attributes[e], a)
+		}
+	}
+	p.schemes = map[string]bool{}
+	for _, s := range p.URLSchemes {
+		s = strings.TrimSuffix(strings.ToLower(s), ":")
+		if unsafeSchemes[s] {
+			return fmt.Errorf("URL scheme '%s' can't be allowed", s)
+		}
+		p.schemes[s] = true
+	}
+	p.drop = nil
+	for _, selector := range p.Drop {
+		cmds, err := ParseCommands(selector)
+		if err != nil {
+			return err
+		}
+		selectorFuncs, err := ParseSelectorFuncs(cmds)
+		if err != nil {
+			return fmt.Errorf("Selector parsing error: %s", err.Error())
+		}
+		p.drop = append(p.drop, selectorFuncs)
+	}
+	return nil
+}
+
+// Remove everything from a document the policy doesn't allow
+func (p *sanitizePolicy) sanitize(root *html.Node) {
+	for _, selectorFuncs := range p.drop {
+		for _, n := range SelectNodes(root, selectorFuncs) {
+			if n.Parent != nil {
+				n.Parent.RemoveChild(n)
+			}
+		}
+	}
+	p.sanitizeChildren(root)
+}
+
+func (p *sanitizePolicy) sanitizeChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			switch {
+			case c.Namespace != "" || !p.elements[c.Data] && droppedElements[c.Data]:
+				// SVG and MathML are parsed differently, so their content
+				// isn't kept even as text
+				n.RemoveChild(c)
+			case !p.elements[c.Data]:
+				p.sanitizeChildren(c)
+				for gc := c.FirstChild; gc != nil; gc = c.FirstChild {
+					c.RemoveChild(gc)
+					n.InsertBefore(gc, c)
+				}
+				n.RemoveChild(c)
+			default:
+				c.Attr = p.sanitizeAttrs(c)
+				p.sanitizeChildren(c)
+			}
+		default:
+			// comments, including conditional comments, and doctypes
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+// The attributes of an element the policy allows, with unsafe URLs and
+// styles removed. Only the first of repeated attributes is kept, as browsers
+// do.
+func (p *sanitizePolicy) sanitizeAttrs(n *html.Node) []html.Attribute {
+	attrs := []html.Attribute{}
+	seen := map[string]bool{}
+	for _, a := range n.Attr {
+		name := attrName(a)
+		if seen[name] || !p.allowsAttr(n.Data, name) {
+			continue
+		}
+		seen[name] = true
+		switch {
+		case name == "style":
+			if !p.safeStyle(a.Val) {
+				continue
+			}
+		case name == "srcset":
+			candidates := []srcsetCandidate{}
+			for _, c := range parseSrcset(a.Val) {
+				if p.safeURL(c.URL) {
+					candidates = append(candidates, c)
+				}
+			}
+			a.Val = formatSrcset(candidates)
+		case name == "ping":
+			urls := []string{}
+			for _, u := range strings.Fields(a.Val) {
+				if p.safeURL(u) {
+					urls = append(urls, u)
+				}
+			}
+			a.Val = strings.Join(urls, " ")
+		case linkAttrs[name] || sanitizeURLAttrs[name]:
+			if !p.safeURL(a.Val) {
+				continue
+			}
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
+
+func (p *sanitizePolicy) allowsAttr(element, name string) bool {
+	// event handlers are never allowed, whatever the policy says
+	if strings.HasPrefix(name, "on") {
+		return false
+	}
+	for _, e := range []string{element, "*"} {
+		for _, allowed := range p.attributes[e] {
+			if allowed == name || strings.HasSuffix(allowed, "*") && strings.HasPrefix(name, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var urlSchemeRe = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+
+// Is a URL relative or does it have an allowed scheme? The URL is read as
+// browsers read it, ignoring surrounding spaces and control characters and
+// any tabs and newlines, so "java\tscript:" has the javascript scheme.
+func (p *sanitizePolicy) safeURL(u string) bool {
+	u = strings.TrimFunc(u, func(r rune) bool { return r <= ' ' })
+	u = strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(u)
+	match := urlSchemeRe.FindStringSubmatch(u)
+	return match == nil || p.schemes[strings.ToLower(match[1])]
+}
+
+var (
+	cssEscapeRe  = regexp.MustCompile(`\\([0-9a-fA-F]{1,6}[ \t\n\r\f]?|[\s\S])`)
+	cssCommentRe = regexp.MustCompile(`/\*[\s\S]*?(\*/|$)`)
+	// unlike cssURLRe, url( is matched whether or not it's closed
+	styleURLRe = regexp.MustCompile(`url\(\s*("[^"]*"|'[^']*'|[^)]*)`)
+)
+
+// Is a style attribute free of scripts and URLs with other schemes? Escapes
+// and comments are removed first, in either order, so they can't hide a
+// word, e.g. "exp/**/ression" or "\65 xpression".
+func (p *sanitizePolicy) safeStyle(style string) bool {
+	for _, css := range []string{
+		cssCommentRe.ReplaceAllString(decodeCSSEscapes(style), ""),
+		decodeCSSEscapes(cssCommentRe.ReplaceAllString(style, "")),
+	} {
+		css = strings.ToLower(css)
+		for _, word := range []string{"expression", "behavior", "-moz-binding", "@import", "javascript:", "vbscript:"} {
+			if strings.Contains(css, word) {
+				return false
+			}
+		}
+		for _, match := range styleURLRe.FindAllStringSubmatch(css, -1) {
+			if !p.safeURL(strings.Trim(match[1], `"' `)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Replace CSS escapes such as \6a or \: with the characters they stand for
+func decodeCSSEscapes(s string) string {
+	return cssEscapeRe.ReplaceAllStringFunc(s, func(escape string) string {
+		hex := strings.TrimRight(escape[1:], " \t\n\r\f")
+		code, err := strconv.ParseUint(hex, 16, 32)
+		switch {
+		case err != nil:
+			// any other character stands for itself
+			return escape[1:]
+		case code == 0 || code > 0x10ffff:
+			return "\ufffd"
+		}
+		return string(rune(code))
+	})
+}
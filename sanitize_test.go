@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func testPolicy(t *testing.T) *sanitizePolicy {
+	p := &sanitizePolicy{
+		Elements: []string{"p", "a", "b", "i", "img", "ul", "li", "span", "div", "table", "tr", "td", "textarea"},
+		Attributes: map[string][]string{
+			"a":   {"href", "ping"},
+			"img": {"src", "srcset", "alt"},
+			"*":   {"class", "title", "style", "data-*"},
+		},
+		URLSchemes: []string{"http", "https", "mailto:"},
+		Drop:       []string{".tracking", "p.signature"},
+	}
+	if err := p.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func sanitizeString(t *testing.T, p *sanitizePolicy, input string) string {
+	root, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.sanitize(root)
+	var buf bytes.Buffer
+	writeHTML(&buf, root, htmlStyle{Exact: true}, false)
+	return buf.String()
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		// elements
+		{`<p>a <b>b</b> <em>c</em></p>`, `<p>a <b>b</b> c</p>`},
+		{`<p>a<script>alert(1)</script></p>`, `<p>a</p>`},
+		{`<SCRIPT SRC=//evil.example/x.js></SCRIPT>b`, `b`},
+		{`<style>p{}</style><p>a</p>`, `<p>a</p>`},
+		{`<iframe src="javascript:alert(1)">a</iframe>b`, `b`},
+		{`<object data="x.swf"><param name=a></object><embed src=x.swf>b`, `b`},
+		{`<template><img src=x onerror=alert(1)></template>b`, `b`},
+		{`<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>b`, `<img src="x">"&gt;b`},
+		{`<xmp></xmp><img src=x onerror=alert(1)></xmp>`, `<img src="x">`},
+		{`<base href="https://evil.example/"><meta http-equiv=refresh content="0;url=javascript:alert(1)">a`, `a`},
+		{`<form action="javascript:alert(1)"><button formaction="javascript:alert(1)">a</button></form>`, `a`},
+		{`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`, ``},
+		{`<svg><script>alert(1)</script><a xlink:href="javascript:alert(1)">a</a></svg>b`, `b`},
+		{`<textarea><script>alert(1)</script></textarea>`, `<textarea>&lt;script&gt;alert(1)&lt;/script&gt;</textarea>`},
+		{`<p class="signature">x</p><p class=tracking>y</p><p>z</p>`, `<p>z</p>`},
+		{`<table><tr><td>a</td></tr></table>`, `<table><tr><td>a</td></tr></table>`},
+
+		// attributes
+		{`<p onclick="alert(1)" class="x">a</p>`, `<p class="x">a</p>`},
+		{`<p ONMOUSEOVER=alert(1)>a</p>`, `<p>a</p>`},
+		{`<img src=x.png alt=x id=y data-id=1>`, `<img src="x.png" alt="x" data-id="1">`},
+		{`<p title=a title=b>a</p>`, `<p title="a">a</p>`},
+		{`<p title='"><script>alert(1)</script>'>a</p>`, `<p title="&quot;><script>alert(1)</script>">a</p>`},
+		{`<p/onclick=alert(1)>a</p>`, `<p>a</p>`},
+
+		// URLs
+		{`<a href="javascript:alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href="JaVaScRiPt:alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href=" javascript:alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href="jav&#x09;ascript:alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href="java&NewLine;script:alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href="&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href="&#x01;javascript:alert(1)">a</a>`, `<a>a</a>`},
+		{`<a href="vbscript:msgbox(1)">a</a>`, `<a>a</a>`},
+		{`<a href="data:text/html,<script>alert(1)</script>">a</a>`, `<a>a</a>`},
+		{`<a href="https://example.com/?q=javascript:">a</a>`, `<a href="https://example.com/?q=javascript:">a</a>`},
+		{`<a href="/relative/javascript:x">a</a>`, `<a href="/relative/javascript:x">a</a>`},
+		{`<a href="MAILTO:a@example.com">a</a>`, `<a href="MAILTO:a@example.com">a</a>`},
+		{`<a href="https://a.example/" ping="https://b.example/ javascript:alert(1)">a</a>`,
+			`<a href="https://a.example/" ping="https://b.example/">a</a>`},
+		{`<img srcset="a.png 1x, javascript:alert(1) 2x, b.png 3x">`, `<img srcset="a.png 1x, b.png 3x">`},
+
+		// styles
+		{`<p style="color: red">a</p>`, `<p style="color: red">a</p>`},
+		{`<p style="width: expression(alert(1))">a</p>`, `<p>a</p>`},
+		{`<p style="width: exp/**/ression(alert(1))">a</p>`, `<p>a</p>`},
+		{`<p style="width: \65 xpression(alert(1))">a</p>`, `<p>a</p>`},
+		{`<p style="width: ex\p\r\65 \s\s\i\o\n(alert(1))">a</p>`, `<p>a</p>`},
+		{`<p style="background: url(javascript:alert(1))">a</p>`, `<p>a</p>`},
+		{`<p style="background: url( 'java\73 cript:alert(1)' )">a</p>`, `<p>a</p>`},
+		{`<p style="background: url(data:image/svg+xml,x">a</p>`, `<p>a</p>`},
+		{`<p style="background: url(https://example.com/a.png)">a</p>`, `<p style="background: url(https://example.com/a.png)">a</p>`},
+		{`<p style="behavior: url(x.htc)">a</p>`, `<p>a</p>`},
+		{`<p style="-moz-binding: url(x.xml#x)">a</p>`, `<p>a</p>`},
+		{`<p style="@import 'x.css'">a</p>`, `<p>a</p>`},
+
+		// comments and CDATA
+		{`<p>a<!-- <script>alert(1)</script> -->b</p>`, `<p>ab</p>`},
+		{`<!--[if IE]><script>alert(1)</script><![endif]-->a`, `a`},
+		{`<!--[if gte IE 4]><SCRIPT>alert(1);</SCRIPT><![endif]-->a`, `a`},
+		{`<!-- --!><img src=x onerror=alert(1)> -->`, `<img src="x"> --&gt;`},
+		{`<p><![CDATA[<script>alert(1)</script>]]></p>`, `<p>alert(1)]]&gt;</p>`},
+		{`<svg><![CDATA[</svg><img src=x onerror=alert(1)>]]></svg>`, ``},
+		{`<p title="<!--"><img src=x onerror=alert(1)>--></p>`, `<p title="<!--"><img src="x">--&gt;</p>`},
+		{`<!DOCTYPE html><p>a</p>`, `<p>a</p>`},
+	}
+	p := testPolicy(t)
+	for _, test := range tests {
+		output := sanitizeString(t, p, test.input)
+		if output != test.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.input, test.expected, output)
+		}
+		// the output must parse to the same tree, or it could be made
+		// unsafe by parsing it again
+		if again := sanitizeString(t, p, output); again != output {
+			t.Errorf("%s: sanitizing again changed\n%s\nto\n%s", test.input, output, again)
+		}
+	}
+}
+
+func TestSanitizePolicy(t *testing.T) {
+	tests := []struct {
+		policy sanitizePolicy
+		err    string
+	}{
+		{sanitizePolicy{Elements: []string{"SCRIPT"}}, "element <script> can't be allowed"},
+		{sanitizePolicy{Elements: []string{"svg"}}, "element <svg> can't be allowed"},
+		{sanitizePolicy{Attributes: map[string][]string{"a": {"onclick"}}}, "attribute 'onclick' can't be allowed"},
+		{sanitizePolicy{Attributes: map[string][]string{"*": {"*"}}}, "attribute '*' can't be allowed"},
+		{sanitizePolicy{URLSchemes: []string{"JavaScript:"}}, "URL scheme 'javascript' can't be allowed"},
+		{sanitizePolicy{Drop: []string{"p:bogus"}}, "Selector parsing error"},
+		{sanitizePolicy{Elements: []string{"p"}, Drop: []string{"script"}}, ""},
+	}
+	for _, test := range tests {
+		err := test.policy.compile()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%v: unexpected error %s", test.policy, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%v: expected error %q got %v", test.policy, test.err, err)
+		}
+	}
+}
+
+func TestSanitizedDisplayer(t *testing.T) {
+	defer func(escape bool) { pupEscapeHTML = escape }(pupEscapeHTML)
+	pupEscapeHTML = false
+	input := `<p>&lt;script&gt;alert(1)&lt;/script&gt; <b>&lt;img src=x onerror=alert(1)&gt;</b></p>`
+	expected := `&lt;script&gt;alert(1)&lt;/script&gt; <b>&lt;img src=x onerror=alert(1)&gt;</b>`
+	for _, d := range []Displayer{TreeDisplayer{}, HTMLDisplayer{}, HTMLDisplayer{Inner: true}, HTMLDisplayer{Minify: true}} {
+		d, err := sanitizedDisplayer(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := html.Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testPolicy(t).sanitize(root)
+		cmds, _ := ParseCommands("p")
+		selectorFuncs, _ := ParseSelectorFuncs(cmds)
+		var buf bytes.Buffer
+		d.Display(&buf, SelectNodes(root, selectorFuncs))
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("%#v: expected the text to stay escaped, got\n%s", d, buf.String())
+		}
+	}
+	for _, d := range []Displayer{TextDisplayer{}, AttrDisplayer{Attrs: []string{"title"}}, RenderDisplayer{}} {
+		if _, err := sanitizedDisplayer(d); err == nil {
+			t.Errorf("%#v: expected an error", d)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Print the structured data of the selected nodes as a single JSON document.
+// JSON-LD scripts, Microdata and RDFa Lite items, and OpenGraph and Twitter
+// card meta tags are gathered.
+type SchemaDisplayer struct{}
+
+type schemaDocument struct {
+	docSource
+	JSONLD    []interface{}          `json:"jsonld"`
+	Microdata []*schemaItem          `json:"microdata"`
+	RDFa      []*schemaItem          `json:"rdfa"`
+	OpenGraph map[string]interface{} `json:"opengraph"`
+	Twitter   map[string]interface{} `json:"twitter"`
+}
+
+// A Microdata or RDFa item. Property values are strings or nested items.
+type schemaItem struct {
+	Type       []string                 `json:"type,omitempty"`
+	ID         string                   `json:"id,omitempty"`
+	Vocab      string                   `json:"vocab,omitempty"`
+	Properties map[string][]interface{} `json:"properties"`
+}
+
+func (s SchemaDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	doc := schemaDocument{
+		JSONLD:    []interface{}{},
+		Microdata: []*schemaItem{},
+		RDFa:      []*schemaItem{},
+		OpenGraph: map[string]interface{}{},
+		Twitter:   map[string]interface{}{},
+	}
+	if len(nodes) > 0 {
+		doc.docSource = documentSource(nodes[0])
+	}
+	for _, node := range nodes {
+		doc.gather(node, "")
+	}
+	data, err := json.MarshalIndent(&doc, "", pupIndentString)
+	if err != nil {
+		panic("Could not jsonify structured data")
+	}
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// Walk a node looking for top level items and meta tags. vocab is the RDFa
+// vocabulary in scope.
+func (doc *schemaDocument) gather(n *html.Node, vocab string) {
+	if n.Type == html.ElementNode {
+		if v, ok := getAttr(n, "vocab"); ok {
+			vocab = v
+		}
+		_, itemscope := getAttr(n, "itemscope")
+		_, itemprop := getAttr(n, "itemprop")
+		_, typeOf := getAttr(n, "typeof")
+		_, property := getAttr(n, "property")
+		switch {
+		case n.DataAtom == atom.Script:
+			doc.gatherJSONLD(n)
+		case n.DataAtom == atom.Meta:
+			doc.gatherMeta(n)
+		}
+		if itemscope && !itemprop {
+			doc.Microdata = append(doc.Microdata, microdataItem(n, map[*html.Node]bool{}))
+		}
+		if typeOf && !property {
+			doc.RDFa = append(doc.RDFa, rdfaItem(n, vocab))
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		doc.gather(c, vocab)
+	}
+}
+
+func (doc *schemaDocument) gatherJSONLD(n *html.Node) {
+	typ, _ := getAttr(n, "type")
+	if !strings.EqualFold(strings.TrimSpace(typ), "application/ld+json") {
+		return
+	}
+	var buf bytes.Buffer
+	writeRawText(&buf, n)
+	var data interface{}
+	if err := json.Unmarshal(buf.Bytes(), &data); err != nil {
+		fmt.Fprintf(os.Stderr, "Skipping invalid JSON-LD: %s\n", err.Error())
+		return
+	}
+	// a list of objects is flattened so every entry is one object
+	if list, ok := data.([]interface{}); ok {
+		doc.JSONLD = append(doc.JSONLD, list...)
+	} else {
+		doc.JSONLD = append(doc.JSONLD, data)
+	}
+}
+
+func (doc *schemaDocument) gatherMeta(n *html.Node) {
+	content, ok := getAttr(n, "content")
+	if !ok {
+		return
+	}
+	key, _ := getAttr(n, "property")
+	if key == "" {
+		key, _ = getAttr(n, "name")
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	switch {
+	case strings.HasPrefix(key, "twitter:"):
+		addMetaValue(doc.Twitter, key, content)
+	case strings.HasPrefix(key, "og:"), strings.HasPrefix(key, "fb:"),
+		strings.HasPrefix(key, "article:"), strings.HasPrefix(key, "book:"),
+		strings.HasPrefix(key, "profile:"), strings.HasPrefix(key, "music:"),
+		strings.HasPrefix(key, "video:"):
+		addMetaValue(doc.OpenGraph, key, content)
+	}
+}
+
+// Add a meta tag value. Repeated tags such as og:image become a list.
+func addMetaValue(m map[string]interface{}, key, val string) {
+	switch curr := m[key].(type) {
+	case nil:
+		m[key] = val
+	case string:
+		m[key] = []string{curr, val}
+	case []string:
+		m[key] = append(curr, val)
+	}
+}
+
+// Build a Microdata item. seen guards against itemref loops.
+func microdataItem(n *html.Node, seen map[*html.Node]bool) *schemaItem {
+	seen[n] = true
+	item := &schemaItem{Properties: map[string][]interface{}{}}
+	if typ, ok := getAttr(n, "itemtype"); ok {
+		item.Type = strings.Fields(typ)
+	}
+	if id, ok := getAttr(n, "itemid"); ok {
+		item.ID = resolveURL(n, strings.TrimSpace(id))
+	}
+	var visit func(c *html.Node)
+	visit = func(c *html.Node) {
+		if c.Type != html.ElementNode || seen[c] {
+			return
+		}
+		_, itemscope := getAttr(c, "itemscope")
+		if props, ok := getAttr(c, "itemprop"); ok {
+			var val interface{}
+			if itemscope {
+				val = microdataItem(c, seen)
+			} else {
+				val = microdataValue(c)
+			}
+			for _, prop := range strings.Fields(props) {
+				item.Properties[prop] = append(item.Properties[prop], val)
+			}
+		}
+		if !itemscope {
+			for cc := c.FirstChild; cc != nil; cc = cc.NextSibling {
+				visit(cc)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		visit(c)
+	}
+	// properties may also live elsewhere in the document
+	if refs, ok := getAttr(n, "itemref"); ok {
+		root := n
+		for root.Parent != nil {
+			root = root.Parent
+		}
+		for _, id := range strings.Fields(refs) {
+			if ref := findByID(root, id); ref != nil {
+				visit(ref)
+			}
+		}
+	}
+	return item
+}
+
+// The value of a Microdata property element
+func microdataValue(n *html.Node) string {
+	var attr string
+	switch n.DataAtom {
+	case atom.Meta:
+		attr = "content"
+	case atom.Audio, atom.Embed, atom.Iframe, atom.Img, atom.Source, atom.Track, atom.Video:
+		attr = "src"
+	case atom.A, atom.Area, atom.Link:
+		attr = "href"
+	case atom.Object:
+		attr = "data"
+	case atom.Data, atom.Meter:
+		attr = "value"
+	case atom.Time:
+		attr = "datetime"
+	}
+	if val, ok := getAttr(n, attr); ok {
+		if linkAttrs[attr] {
+			return resolveURL(n, strings.TrimSpace(val))
+		}
+		return val
+	}
+	return nodeText(n)
+}
+
+// Build an RDFa Lite item from an element with a typeof attribute
+func rdfaItem(n *html.Node, vocab string) *schemaItem {
+	item := &schemaItem{Vocab: vocab, Properties: map[string][]interface{}{}}
+	if typ, _ := getAttr(n, "typeof"); strings.TrimSpace(typ) != "" {
+		item.Type = strings.Fields(typ)
+	}
+	if id, ok := getAttr(n, "resource"); ok {
+		item.ID = resolveURL(n, strings.TrimSpace(id))
+	}
+	var crawl func(n *html.Node, vocab string)
+	crawl = func(n *html.Node, vocab string) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			childVocab := vocab
+			if v, ok := getAttr(c, "vocab"); ok {
+				childVocab = v
+			}
+			_, typeOf := getAttr(c, "typeof")
+			if props, ok := getAttr(c, "property"); ok {
+				var val interface{}
+				if typeOf {
+					val = rdfaItem(c, childVocab)
+				} else {
+					val = rdfaValue(c)
+				}
+				for _, prop := range strings.Fields(props) {
+					item.Properties[prop] = append(item.Properties[prop], val)
+				}
+			}
+			if !typeOf {
+				crawl(c, childVocab)
+			}
+		}
+	}
+	crawl(n, vocab)
+	return item
+}
+
+// The value of an RDFa property element
+func rdfaValue(n *html.Node) string {
+	if content, ok := getAttr(n, "content"); ok {
+		return content
+	}
+	for _, attr := range []string{"resource", "href", "src"} {
+		if val, ok := getAttr(n, attr); ok {
+			return resolveURL(n, strings.TrimSpace(val))
+		}
+	}
+	if n.DataAtom == atom.Time {
+		if val, ok := getAttr(n, "datetime"); ok {
+			return val
+		}
+	}
+	return nodeText(n)
+}
+
+// The text of a node with whitespace collapsed
+func nodeText(n *html.Node) string {
+	var buf bytes.Buffer
+	writeRawText(&buf, n)
+	return strings.TrimSpace(collapseSpace(buf.String()))
+}
+
+// Find the element with an id
+func findByID(n *html.Node, id string) *html.Node {
+	if n.Type == html.ElementNode {
+		if v, ok := getAttr(n, "id"); ok && v == id {
+			return n
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findByID(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
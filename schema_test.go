@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	tests := []struct {
+		input, section, expected string
+	}{
+		// JSON-LD
+		{`<script type="application/ld+json">{"@type": "Person", "name": "a"}</script>`,
+			"jsonld", `[{"@type":"Person","name":"a"}]`},
+		{`<script type="application/ld+json">[{"@type": "A"}, {"@type": "B"}]</script><script type=" Application/LD+JSON ">{"@type": "C"}</script>`,
+			"jsonld", `[{"@type":"A"},{"@type":"B"},{"@type":"C"}]`},
+		{`<script type="application/ld+json">{"@graph": [{"@type": "A"}]}</script>`,
+			"jsonld", `[{"@graph":[{"@type":"A"}]}]`},
+		{`<script type="application/ld+json">{bad</script><script>{"@type": "A"}</script>`,
+			"jsonld", `[]`},
+
+		// Microdata
+		{`<div itemscope itemtype="https://schema.org/Person"><span itemprop="name">  a  b </span><a itemprop="url" href="/a">x</a></div>`,
+			"microdata", `[{"type":["https://schema.org/Person"],"properties":{"name":["a b"],"url":["/a"]}}]`},
+		{`<div itemscope><span itemprop="a b">x</span><meta itemprop=c content=y><time itemprop=d datetime=2020>x</time><data itemprop=e value=1>x</data></div>`,
+			"microdata", `[{"properties":{"a":["x"],"b":["x"],"c":["y"],"d":["2020"],"e":["1"]}}]`},
+		{`<div itemscope itemid=" urn:a "><div itemscope itemprop=author itemtype=Person><span itemprop=name>a</span></div><span itemprop=name>b</span></div>`,
+			"microdata", `[{"id":"urn:a","properties":{"author":[{"type":["Person"],"properties":{"name":["a"]}}],"name":["b"]}}]`},
+		{`<div itemscope><div itemscope><span itemprop=name>a</span></div></div>`,
+			"microdata", `[{"properties":{}},{"properties":{"name":["a"]}}]`},
+		{`<div itemscope itemref="x y"><span itemprop=a>1</span></div><p id=x itemprop=b>2</p><div id=y><span itemprop=c>3</span></div>`,
+			"microdata", `[{"properties":{"a":["1"],"b":["2"],"c":["3"]}}]`},
+		{`<div id=x itemscope itemref=x><span itemprop=a>1</span></div>`,
+			"microdata", `[{"properties":{"a":["1"]}}]`},
+		{`<div id=a itemscope itemref=b><span itemprop=x>1</span></div><div id=b itemprop=y itemscope itemref=a><span itemprop=z>2</span></div>`,
+			"microdata", `[{"properties":{"x":["1"],"y":[{"properties":{"z":["2"]}}]}}]`},
+
+		// RDFa Lite
+		{`<div vocab="https://schema.org/" typeof=Person resource="#me"><span property=name>a</span><div property=address typeof=PostalAddress><span property=postalCode>1</span></div></div>`,
+			"rdfa", `[{"type":["Person"],"id":"#me","vocab":"https://schema.org/","properties":{"address":[{"type":["PostalAddress"],"vocab":"https://schema.org/","properties":{"postalCode":["1"]}}],"name":["a"]}}]`},
+		{`<div typeof=""><meta property=a content=x><a property=b href=/b>y</a><time property=c datetime=2020>z</time></div>`,
+			"rdfa", `[{"properties":{"a":["x"],"b":["/b"],"c":["2020"]}}]`},
+
+		// meta tags
+		{`<meta property="og:image" content=a><meta property="OG:image" content=b><meta property="og:image" content=c><meta property="article:author" content=d><meta name=description content=e>`,
+			"opengraph", `{"article:author":"d","og:image":["a","b","c"]}`},
+		{`<meta name="twitter:card" content=summary><meta property="twitter:site" content="@a"><meta name="twitter:title">`,
+			"twitter", `{"twitter:card":"summary","twitter:site":"@a"}`},
+	}
+	for _, test := range tests {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(displayString(t, SchemaDisplayer{}, test.input, "html")), &doc); err != nil {
+			t.Fatalf("%s: %s", test.input, err)
+		}
+		var output bytes.Buffer
+		if err := json.Compact(&output, doc[test.section]); err != nil {
+			t.Fatalf("%s: %s", test.input, err)
+		}
+		if output.String() != test.expected {
+			t.Errorf("%s: expected %s\n%s\ngot\n%s", test.input, test.section, test.expected, output.String())
+		}
+	}
+}
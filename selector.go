@@ -40,6 +40,48 @@ func Select(s Selector) SelectorFunc {
 	}
 }
 
+// Parse a list of commands into selector functions. A nil function in the
+// result stands for a comma.
+func ParseSelectorFuncs(cmds []string) ([]SelectorFunc, error) {
+	selectorFuncs := []SelectorFunc{}
+	funcGenerator := Select
+	for _, cmd := range cmds {
+		switch cmd {
+		case "*": // select all
+			continue
+		case ">":
+			funcGenerator = SelectFromChildren
+		case "+":
+			funcGenerator = SelectNextSibling
+		case ",": // nil will signify a comma
+			selectorFuncs = append(selectorFuncs, nil)
+		default:
+			selector, err := ParseSelector(cmd)
+			if err != nil {
+				return nil, err
+			}
+			selectorFuncs = append(selectorFuncs, funcGenerator(selector))
+			funcGenerator = Select
+		}
+	}
+	return selectorFuncs, nil
+}
+
+// Apply selector functions to a root node and return the selected nodes
+func SelectNodes(root *html.Node, selectorFuncs []SelectorFunc) []*html.Node {
+	selectedNodes := []*html.Node{}
+	currNodes := []*html.Node{root}
+	for _, selectorFunc := range selectorFuncs {
+		if selectorFunc == nil { // hit a comma
+			selectedNodes = append(selectedNodes, currNodes...)
+			currNodes = []*html.Node{root}
+		} else {
+			currNodes = selectorFunc(currNodes)
+		}
+	}
+	return append(selectedNodes, currNodes...)
+}
+
 // Defined for the '>' selector
 func SelectNextSibling(s Selector) SelectorFunc {
 	return func(nodes []*html.Node) []*html.Node {
@@ -86,7 +128,7 @@ func (s CSSSelector) Match(node *html.Node) bool {
 		return false
 	}
 	if s.Tag != "" {
-		if s.Tag != node.DataAtom.String() {
+		if s.Tag != node.Data {
 			return false
 		}
 	}
@@ -125,8 +167,8 @@ func ParseSelector(cmd string) (selector CSSSelector, err error) {
 	return
 }
 
-// Parse the initial tag
-// e.g. `div`
+// Parse the initial tag, where '|' separates a namespace prefix
+// e.g. `div` or `atom|link`
 func ParseTagMatcher(selector *CSSSelector, s scanner.Scanner) error {
 	tag := bytes.NewBuffer([]byte{})
 	defer func() {
@@ -145,6 +187,8 @@ func ParseTagMatcher(selector *CSSSelector, s scanner.Scanner) error {
 			return ParseAttrMatcher(selector, s)
 		case ':':
 			return ParsePseudo(selector, s)
+		case '|':
+			tag.WriteRune(':')
 		default:
 			if _, err := tag.WriteRune(c); err != nil {
 				return err
@@ -418,7 +462,7 @@ func firstOfTypePseudo(node *html.Node) bool {
 		return false
 	}
 	for n := node.PrevSibling; n != nil; n = n.PrevSibling {
-		if n.DataAtom == node.DataAtom {
+		if n.Type == html.ElementNode && n.Data == node.Data {
 			return false
 		}
 	}
@@ -431,7 +475,7 @@ func lastOfTypePseudo(node *html.Node) bool {
 		return false
 	}
 	for n := node.NextSibling; n != nil; n = n.NextSibling {
-		if n.DataAtom == node.DataAtom {
+		if n.Type == html.ElementNode && n.Data == node.Data {
 			return false
 		}
 	}
@@ -462,7 +506,7 @@ func parseNthPseudo(cmd string) (PseudoClass, error) {
 		countNth = func(n *html.Node) int {
 			nth := 1
 			for sib := n.PrevSibling; sib != nil; sib = sib.PrevSibling {
-				if sib.Type == html.ElementNode && sib.DataAtom == n.DataAtom {
+				if sib.Type == html.ElementNode && sib.Data == n.Data {
 					nth++
 				}
 			}
@@ -482,7 +526,7 @@ func parseNthPseudo(cmd string) (PseudoClass, error) {
 		countNth = func(n *html.Node) int {
 			nth := 1
 			for sib := n.NextSibling; sib != nil; sib = sib.NextSibling {
-				if sib.Type == html.ElementNode && sib.DataAtom == n.DataAtom {
+				if sib.Type == html.ElementNode && sib.Data == n.Data {
 					nth++
 				}
 			}
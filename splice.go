@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A change to the source of a document: the text from Start to End is
+// replaced, or inserted at Start if they're equal
+type sourceSplice struct {
+	Start, End int
+	Text       string
+	// Inserts at the same offset go in the order they were made, except for
+	// those closing an element, which go first in reverse order
+	Close bool
+	seq   int
+}
+
+// Where an element is in the source: its start tag from Start to StartEnd
+// and its end tag from EndStart to End, which are equal if it was implied
+type sourceSpan struct {
+	Start, StartEnd, EndStart, End int
+}
+
+// A part of an edited document, either copied from its source or written
+// by an edit
+type sourcePiece struct {
+	Text   string
+	Edited bool
+}
+
+// The edits made to a document as changes to its source, so editing a file
+// in place leaves what wasn't edited as it was written. ok is cleared when
+// an edit can't be made to the source, e.g. to a node another edit added.
+type sourceEdits struct {
+	content   []byte
+	positions map[*html.Node]sourcePos
+	// the offset of the first start tag of the elements after each element
+	// and its parent, before the document was edited
+	following map[*html.Node]int
+	parents   map[*html.Node]*html.Node
+	splices   []sourceSplice
+	seq       int
+	ok        bool
+}
+
+func newSourceEdits(root *html.Node, content []byte) *sourceEdits {
+	s := &sourceEdits{
+		content:   content,
+		positions: parsePositions(root, content),
+		following: map[*html.Node]int{},
+		parents:   map[*html.Node]*html.Node{},
+		ok:        true,
+	}
+	// walk the document backwards so the next start tag is known
+	next := len(content)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		s.following[n] = next
+		for c := n.LastChild; c != nil; c = c.PrevSibling {
+			s.parents[c] = n
+			walk(c)
+		}
+		if pos, ok := s.positions[n]; ok && pos.Offset < next {
+			next = pos.Offset
+		}
+	}
+	walk(root)
+	return s
+}
+
+// Apply edits to a document as applyEdits does, recording the changes to
+// its source
+func (s *sourceEdits) applyEdits(root *html.Node, edits []editAction) int {
+	changed := 0
+	for _, edit := range edits {
+		for _, n := range SelectNodes(root, edit.SelectorFuncs) {
+			if n.Type != html.ElementNode || n.Parent == nil {
+				continue
+			}
+			span, found := s.span(n)
+			parent, prev, next := n.Parent, n.PrevSibling, n.NextSibling
+			if !applyEdit(n, edit) {
+				continue
+			}
+			changed++
+			if !found {
+				s.ok = false
+			} else if s.ok {
+				s.record(n, edit, span, parent, prev, next)
+			}
+		}
+	}
+	return changed
+}
+
+// Find an element in the source. Its end tag is the one which closes it
+// before the next element which isn't in it, unless that's implied by a start
+// tag or the end tag of an element it's in.
+func (s *sourceEdits) span(n *html.Node) (sourceSpan, bool) {
+	pos, ok := s.positions[n]
+	limit := s.following[n]
+	if !ok || limit < pos.Offset {
+		return sourceSpan{}, false
+	}
+	z := html.NewTokenizer(bytes.NewReader(s.content[pos.Offset:limit]))
+	tt := z.Next()
+	if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+		return sourceSpan{}, false
+	}
+	offset := pos.Offset + len(z.Raw())
+	span := sourceSpan{pos.Offset, offset, offset, offset}
+	if isVoidElement(n) || tt == html.SelfClosingTagToken && n.Namespace != "" {
+		return span, true
+	}
+	closing := map[string]bool{}
+	for p := s.parents[n]; p != nil; p = s.parents[p] {
+		if p.Type == html.ElementNode {
+			closing[strings.ToLower(p.Data)] = true
+		}
+	}
+	// follow the elements opened in this one, as the parser would
+	open := []string{strings.ToLower(n.Data)}
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return span, true
+		}
+		name, _ := z.TagName()
+		tag := string(name)
+		switch tt {
+		case html.StartTagToken:
+			for len(open) > 0 && impliesEnd(tag, open[len(open)-1]) {
+				open = open[:len(open)-1]
+			}
+			if len(open) == 0 {
+				return span, true
+			}
+			if !isVoidElement(&html.Node{Type: html.ElementNode, Data: tag, DataAtom: atom.Lookup(name)}) {
+				open = append(open, tag)
+			}
+		case html.EndTagToken:
+			i := len(open) - 1
+			for i >= 0 && open[i] != tag {
+				i--
+			}
+			switch {
+			case i == 0:
+				span.End = offset + len(z.Raw())
+				return span, true
+			case i > 0:
+				open = open[:i]
+			case closing[tag]:
+				return span, true
+			}
+		}
+		offset += len(z.Raw())
+		span.EndStart, span.End = offset, offset
+	}
+}
+
+// Does a start tag end an open element without its end tag?
+func impliesEnd(tag, open string) bool {
+	switch tag {
+	case "li":
+		return open == "li" || open == "p"
+	case "dt", "dd":
+		return open == "dt" || open == "dd" || open == "p"
+	case "td", "th":
+		return open == "td" || open == "th"
+	case "tr":
+		return open == "tr" || open == "td" || open == "th"
+	case "tbody", "thead", "tfoot":
+		return open == "tbody" || open == "thead" || open == "tfoot" || open == "tr" || open == "td" || open == "th"
+	case "option":
+		return open == "option"
+	case "optgroup":
+		return open == "option" || open == "optgroup"
+	}
+	return open == "p" && closesParagraph[tag]
+}
+
+// Record an edit made to an element found in the source. parent, prev and
+// next are where it was before the edit.
+func (s *sourceEdits) record(n *html.Node, edit editAction, span sourceSpan, parent, prev, next *html.Node) {
+	switch edit.Kind {
+	case "delete":
+		s.add(sourceSplice{Start: span.Start, End: span.End})
+	case "unwrap":
+		s.add(sourceSplice{Start: span.Start, End: span.StartEnd})
+		s.add(sourceSplice{Start: span.EndStart, End: span.End})
+	case "set-attr", "remove-attr":
+		start, _, _ := serializeParts(n)
+		s.add(sourceSplice{Start: span.Start, End: span.StartEnd, Text: start})
+	case "set-text":
+		_, content, _ := serializeParts(n)
+		s.add(sourceSplice{Start: span.StartEnd, End: span.EndStart, Text: content})
+	case "replace":
+		var buf bytes.Buffer
+		c := parent.FirstChild
+		if prev != nil {
+			c = prev.NextSibling
+		}
+		for ; c != next; c = c.NextSibling {
+			writeHTML(&buf, c, htmlStyle{Exact: true}, false)
+		}
+		s.add(sourceSplice{Start: span.Start, End: span.End, Text: buf.String()})
+	case "wrap":
+		// write the wrapper without the node to find the markup around it
+		wrapper, inner := n.Parent, n.Parent
+		for wrapper.Parent != parent {
+			wrapper = wrapper.Parent
+		}
+		inner.RemoveChild(n)
+		var buf bytes.Buffer
+		writeHTML(&buf, wrapper, htmlStyle{Exact: true}, false)
+		inner.AppendChild(n)
+		end := ""
+		for e := inner; e != parent; e = e.Parent {
+			end += "</" + e.Data + ">"
+		}
+		if !strings.HasSuffix(buf.String(), end) {
+			s.ok = false
+			return
+		}
+		s.add(sourceSplice{Start: span.Start, End: span.Start, Text: strings.TrimSuffix(buf.String(), end)})
+		s.add(sourceSplice{Start: span.End, End: span.End, Text: end, Close: true})
+	}
+}
+
+// The start tag, content and end tag an element is written with
+func serializeParts(n *html.Node) (start, content, end string) {
+	var buf bytes.Buffer
+	writeHTML(&buf, &html.Node{Type: n.Type, DataAtom: n.DataAtom, Data: n.Data, Namespace: n.Namespace, Attr: n.Attr}, htmlStyle{Exact: true}, false)
+	end = "</" + n.Data + ">"
+	start = strings.TrimSuffix(buf.String(), end)
+	if !strings.HasSuffix(buf.String(), end) {
+		end = ""
+	}
+	buf.Reset()
+	writeHTML(&buf, n, htmlStyle{Exact: true}, false)
+	content = strings.TrimSuffix(strings.TrimPrefix(buf.String(), start), end)
+	return start, content, end
+}
+
+// Add a change to the source. Changes within the text it replaces are
+// dropped, and one which overlaps another can't be made.
+func (s *sourceEdits) add(sp sourceSplice) {
+	if sp.Start == sp.End && sp.Text == "" {
+		return
+	}
+	s.seq++
+	sp.seq = s.seq
+	splices := []sourceSplice{}
+	for _, o := range s.splices {
+		oInsert, spInsert := o.Start == o.End, sp.Start == sp.End
+		switch {
+		case oInsert && spInsert:
+		case oInsert:
+			if sp.Start < o.Start && o.Start < sp.End {
+				continue
+			}
+		case spInsert:
+			if o.Start < sp.Start && sp.Start < o.End {
+				s.ok = false
+				return
+			}
+		case sp.Start <= o.Start && o.End <= sp.End:
+			continue
+		case o.Start < sp.End && sp.Start < o.End:
+			s.ok = false
+			return
+		}
+		splices = append(splices, o)
+	}
+	s.splices = append(splices, sp)
+}
+
+// The edited document as pieces of its source and the text of the edits
+func (s *sourceEdits) pieces() ([]sourcePiece, bool) {
+	splices := append([]sourceSplice{}, s.splices...)
+	// at the same offset, end tags of wrappers go first, then other inserts,
+	// then replaced text
+	order := func(sp sourceSplice) int {
+		switch {
+		case sp.Start != sp.End:
+			return 2
+		case sp.Close:
+			return 0
+		}
+		return 1
+	}
+	sort.SliceStable(splices, func(i, j int) bool {
+		a, b := splices[i], splices[j]
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		if order(a) != order(b) {
+			return order(a) < order(b)
+		}
+		if a.Close {
+			return a.seq > b.seq
+		}
+		return a.seq < b.seq
+	})
+	pieces := []sourcePiece{}
+	cursor := 0
+	for _, sp := range splices {
+		if sp.Start < cursor {
+			return nil, false
+		}
+		pieces = append(pieces,
+			sourcePiece{Text: string(s.content[cursor:sp.Start])},
+			sourcePiece{Text: sp.Text, Edited: true})
+		cursor = sp.End
+	}
+	pieces = append(pieces, sourcePiece{Text: string(s.content[cursor:])})
+	return pieces, true
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A selector which can be decided from the stack of open elements, made of
+// tags, classes, ids and attributes joined by descendant and child
+// combinators. Each group is one side of a comma.
+type streamSelector struct {
+	Groups [][]streamStep
+}
+
+type streamStep struct {
+	Selector CSSSelector
+	// Only match children of the previous step, for '>'
+	Child bool
+}
+
+// Parse commands as a streamSelector, mirroring ParseSelectorFuncs. ok is
+// false if they use a pseudo class, '+' or select the whole document.
+func parseStreamSelector(cmds []string) (sel *streamSelector, ok bool) {
+	sel = &streamSelector{Groups: [][]streamStep{{}}}
+	child := false
+	for _, cmd := range cmds {
+		switch cmd {
+		case "*":
+			continue
+		case ">":
+			child = true
+		case "+":
+			return nil, false
+		case ",":
+			sel.Groups = append(sel.Groups, []streamStep{})
+		default:
+			selector, err := ParseSelector(cmd)
+			if err != nil || selector.Pseudo != nil {
+				return nil, false
+			}
+			last := len(sel.Groups) - 1
+			sel.Groups[last] = append(sel.Groups[last], streamStep{selector, child})
+			child = false
+		}
+	}
+	for _, group := range sel.Groups {
+		if len(group) == 0 {
+			return nil, false
+		}
+	}
+	return sel, true
+}
+
+// Can a displayer print each node as it's found? Displayers which print a
+// single document for all nodes can't.
+func isStreamDisplayer(d Displayer) bool {
+	switch d := d.(type) {
+	case TreeDisplayer, TextDisplayer, AttrDisplayer, HTMLDisplayer, NumDisplayer, LocDisplayer:
+		return true
+	case LinksDisplayer:
+		return !d.JSON
+	}
+	return false
+}
+
+// An open element while streaming
+type streamElement struct {
+	node *html.Node
+	// For each group, the number of steps matched by this element or its
+	// nearest ancestor which matched one, and whether it was this element
+	stages []int
+	staged []bool
+	// Selected, or within a selected element, so its children are kept
+	keep     bool
+	selected bool
+}
+
+// Work out how far through each group an element gets. As with Select, the
+// descendants of an element which matches a step can't match that step too.
+func (s *streamSelector) enter(parent *streamElement, n *html.Node) *streamElement {
+	e := &streamElement{
+		node:   n,
+		stages: make([]int, len(s.Groups)),
+		staged: make([]bool, len(s.Groups)),
+		keep:   parent.keep,
+	}
+	for i, group := range s.Groups {
+		stage := parent.stages[i]
+		e.stages[i] = stage
+		if stage == len(group) {
+			continue
+		}
+		step := group[stage]
+		if (!step.Child || parent.staged[i]) && step.Selector.Match(n) {
+			e.stages[i]++
+			e.staged[i] = true
+			if e.stages[i] == len(group) {
+				e.selected = true
+				e.keep = true
+			}
+		}
+	}
+	return e
+}
+
+// Start tags which close open elements, as long as no scope element is
+// found first. This approximates the implied end tags of the HTML parser.
+var streamImpliedEnds = map[atom.Atom]struct{ closes, scope []atom.Atom }{
+	atom.Li:       {[]atom.Atom{atom.Li}, []atom.Atom{atom.Ul, atom.Ol}},
+	atom.Dt:       {[]atom.Atom{atom.Dt, atom.Dd}, []atom.Atom{atom.Dl}},
+	atom.Dd:       {[]atom.Atom{atom.Dt, atom.Dd}, []atom.Atom{atom.Dl}},
+	atom.Tr:       {[]atom.Atom{atom.Tr, atom.Td, atom.Th}, []atom.Atom{atom.Table, atom.Tbody, atom.Thead, atom.Tfoot}},
+	atom.Td:       {[]atom.Atom{atom.Td, atom.Th}, []atom.Atom{atom.Tr, atom.Table}},
+	atom.Th:       {[]atom.Atom{atom.Td, atom.Th}, []atom.Atom{atom.Tr, atom.Table}},
+	atom.Tbody:    {[]atom.Atom{atom.Tbody, atom.Thead, atom.Tfoot, atom.Tr, atom.Td, atom.Th}, []atom.Atom{atom.Table}},
+	atom.Thead:    {[]atom.Atom{atom.Tbody, atom.Thead, atom.Tfoot, atom.Tr, atom.Td, atom.Th}, []atom.Atom{atom.Table}},
+	atom.Tfoot:    {[]atom.Atom{atom.Tbody, atom.Thead, atom.Tfoot, atom.Tr, atom.Td, atom.Th}, []atom.Atom{atom.Table}},
+	atom.Option:   {[]atom.Atom{atom.Option}, []atom.Atom{atom.Select, atom.Datalist, atom.Optgroup}},
+	atom.Optgroup: {[]atom.Atom{atom.Option, atom.Optgroup}, []atom.Atom{atom.Select}},
+}
+
+// Block elements which close an open <p>
+var streamClosesP = []atom.Atom{
+	atom.Address, atom.Article, atom.Aside, atom.Blockquote, atom.Details,
+	atom.Div, atom.Dl, atom.Fieldset, atom.Figcaption, atom.Figure,
+	atom.Footer, atom.Form, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5,
+	atom.H6, atom.Header, atom.Hr, atom.Menu, atom.Nav, atom.Ol, atom.P,
+	atom.Pre, atom.Section, atom.Table, atom.Ul, atom.Li, atom.Dd, atom.Dt,
+}
+
+var streamPScope = []atom.Atom{
+	atom.Applet, atom.Button, atom.Caption, atom.Html, atom.Marquee,
+	atom.Object, atom.Table, atom.Td, atom.Template, atom.Th,
+}
+
+func hasAtom(atoms []atom.Atom, a atom.Atom) bool {
+	for _, b := range atoms {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// The number of open elements a start tag implicitly closes
+func impliedEnds(stack []*streamElement, a atom.Atom) int {
+	closes, scope := []atom.Atom(nil), []atom.Atom(nil)
+	if ends, ok := streamImpliedEnds[a]; ok {
+		closes, scope = ends.closes, ends.scope
+	}
+	if hasAtom(streamClosesP, a) {
+		closes, scope = append(closes, atom.P), append(scope, streamPScope...)
+	}
+	n := 0
+	for i := len(stack) - 1; i > 0; i-- {
+		open := stack[i].node.DataAtom
+		if hasAtom(closes, open) {
+			n = len(stack) - i
+		} else if hasAtom(scope, open) {
+			break
+		}
+	}
+	return n
+}
+
+// The elements the parser inserts between an open element and a start tag,
+// e.g. the <tbody> of a <tr> in a <table>
+func impliedStarts(open, a atom.Atom) []atom.Atom {
+	switch a {
+	case atom.Tr:
+		if open == atom.Table {
+			return []atom.Atom{atom.Tbody}
+		}
+	case atom.Td, atom.Th:
+		switch open {
+		case atom.Table:
+			return []atom.Atom{atom.Tbody, atom.Tr}
+		case atom.Tbody, atom.Thead, atom.Tfoot:
+			return []atom.Atom{atom.Tr}
+		}
+	case atom.Col:
+		if open == atom.Table {
+			return []atom.Atom{atom.Colgroup}
+		}
+	}
+	return nil
+}
+
+// Elements which go in the <head> if they come before the body
+var headElements = []atom.Atom{
+	atom.Base, atom.Basefont, atom.Bgsound, atom.Link, atom.Meta,
+	atom.Noframes, atom.Noscript, atom.Script, atom.Style, atom.Template,
+	atom.Title,
+}
+
+// Is an element within <svg> or <math>, where tags may be self-closing?
+func inForeignContent(stack []*streamElement) bool {
+	for _, e := range stack {
+		if e.node.DataAtom == atom.Svg || e.node.DataAtom == atom.Math {
+			return true
+		}
+	}
+	return false
+}
+
+// Query a document as it's tokenized rather than building the whole tree.
+// Only the open elements and the selected ones are kept, and each selected
+// node is displayed as soon as it closes. Nodes are displayed in document
+// order, or rather the order they close, even with commas.
+func streamHTML(w io.Writer, r io.Reader, src docSource, sel *streamSelector, selectorFuncs []SelectorFunc) error {
+	rc, err := decompress(r, pupDecompress)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	br := bufio.NewReader(rc)
+	// archives and the charset are recognized from what has been read when
+	// the input starts, rather than waiting for more of a document which is
+	// still being written
+	br.Peek(1)
+	arrived, _ := br.Peek(br.Buffered())
+	if isMHTMLHeader(arrived) {
+		// archives are read into memory anyway
+		root, err := parseMHTML(br, pupCharset)
+		if err != nil {
+			return err
+		}
+		return queryDocument(w, root, src, selectorFuncs)
+	}
+	if len(arrived) > 1024 {
+		arrived = arrived[:1024]
+	}
+	decoded, info, err := decodePreviewed(br, arrived, pupCharset, "")
+	if err != nil {
+		return err
+	}
+
+	root := &html.Node{Type: html.DocumentNode}
+	setDocumentCharset(root, info)
+	startDocument(root, src)
+	defer releaseDocument(root)
+	if !pupFilesWithMatches {
+		w = resultWriter(w, src)
+	}
+	if pupShowCharset {
+		fmt.Fprintf(w, "%s\t%s\n", info.Name, info.Source)
+		return nil
+	}
+
+	stack := []*streamElement{{
+		node:   root,
+		stages: make([]int, len(sel.Groups)),
+		staged: make([]bool, len(sel.Groups)),
+	}}
+	for i := range stack[0].staged {
+		stack[0].staged[i] = true
+	}
+	count := 0
+	// the error of a displayer which failed, which stops the stream
+	var failed error
+	// close the top n elements, displaying the selected ones
+	pop := func(n int) bool {
+		for ; n > 0; n-- {
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+			if e.selected {
+				count++
+				if pupFilesWithMatches {
+					fmt.Fprintln(w, src.name())
+					return false
+				}
+				if _, ok := pupDisplayer.(NumDisplayer); !ok {
+					if failed = display(pupDisplayer, w, []*html.Node{e.node}); failed != nil {
+						return false
+					}
+				}
+			}
+			if !parent.keep {
+				parent.node.RemoveChild(e.node)
+			}
+		}
+		return true
+	}
+
+	push := func(n *html.Node) {
+		parent := stack[len(stack)-1]
+		parent.node.AppendChild(n)
+		stack = append(stack, sel.enter(parent, n))
+	}
+
+	// The <html>, <head> and <body> elements, which the parser implies when
+	// their tags are left out. Fragments have none.
+	var htmlNode, headNode, bodyNode *html.Node
+	headClosed := pupFragment != ""
+	if pupFragment != "" {
+		htmlNode, bodyNode = root, root
+	}
+	implied := func(a atom.Atom) *html.Node {
+		n := &html.Node{Type: html.ElementNode, Data: a.String(), DataAtom: a}
+		push(n)
+		return n
+	}
+	// Open the elements the parser implies before a start tag, or text if a
+	// is 0. skip is set for tags of elements which are already open, which
+	// the parser merges or ignores.
+	implyDocument := func(a atom.Atom) (skip, ok bool) {
+		switch {
+		case htmlNode == nil && a == atom.Html:
+			return false, true
+		case htmlNode == nil:
+			htmlNode = implied(atom.Html)
+		case a == atom.Html:
+			return true, true
+		}
+		if bodyNode != nil {
+			return a == atom.Head || a == atom.Body, true
+		}
+		if !headClosed && hasAtom(headElements, a) {
+			if headNode == nil {
+				headNode = implied(atom.Head)
+			}
+			return false, true
+		}
+		if a == atom.Head {
+			return headNode != nil, true
+		}
+		// anything else starts the body, closing the head, which is there
+		// even if it's empty
+		if headNode == nil {
+			headNode = implied(atom.Head)
+		}
+		if !headClosed {
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].node == headNode {
+					if !pop(len(stack) - i) {
+						return false, false
+					}
+					break
+				}
+			}
+		}
+		headClosed = true
+		if a != atom.Body {
+			bodyNode = implied(atom.Body)
+		}
+		return false, true
+	}
+
+	var positions map[*html.Node]sourcePos
+	if trackPositions() {
+		positions = map[*html.Node]sourcePos{}
+		setDocumentPositions(root, positions)
+	}
+	pos := startPos
+	z := html.NewTokenizer(decoded)
+	for {
+		tt := z.Next()
+		start := pos
+		if positions != nil && tt != html.ErrorToken {
+			pos.advance(z.Raw())
+		}
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return z.Err()
+			}
+			if pop(len(stack) - 1) {
+				if _, ok := pupDisplayer.(NumDisplayer); ok {
+					fmt.Fprintln(w, count)
+				}
+			}
+			return failed
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			skip, ok := implyDocument(tok.DataAtom)
+			if !ok {
+				return failed
+			}
+			if skip {
+				continue
+			}
+			if !pop(impliedEnds(stack, tok.DataAtom)) {
+				return failed
+			}
+			for _, a := range impliedStarts(stack[len(stack)-1].node.DataAtom, tok.DataAtom) {
+				push(&html.Node{Type: html.ElementNode, Data: a.String(), DataAtom: a})
+			}
+			n := &html.Node{
+				Type:     html.ElementNode,
+				Data:     tok.Data,
+				DataAtom: tok.DataAtom,
+				Attr:     tok.Attr,
+			}
+			if positions != nil {
+				positions[n] = start
+			}
+			push(n)
+			switch {
+			case n.DataAtom == atom.Html && htmlNode == nil:
+				htmlNode = n
+			case n.DataAtom == atom.Head && headNode == nil:
+				headNode = n
+			case n.DataAtom == atom.Body && bodyNode == nil:
+				bodyNode = n
+			}
+			if n.DataAtom == atom.Base {
+				// keep <base> so URLs resolve as they would in the tree
+				root.AppendChild(&html.Node{Type: html.ElementNode, Data: n.Data, DataAtom: n.DataAtom, Attr: n.Attr})
+			}
+			if isVoidElement(n) || (tt == html.SelfClosingTagToken && inForeignContent(stack)) {
+				if !pop(1) {
+					return failed
+				}
+			}
+		case html.EndTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Head:
+				headClosed = true
+			case atom.Body, atom.Html:
+				// the parser keeps them open for anything after them
+				continue
+			}
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].node.Data == tok.Data {
+					if !pop(len(stack) - i) {
+						return failed
+					}
+					break
+				}
+			}
+		case html.TextToken, html.CommentToken:
+			tok := z.Token()
+			if top := stack[len(stack)-1].node; tt == html.TextToken && bodyNode == nil &&
+				(top == root || top == htmlNode || top == headNode) && strings.TrimSpace(tok.Data) != "" {
+				// text outside the head starts the body
+				if _, ok := implyDocument(0); !ok {
+					return failed
+				}
+			}
+			if parent := stack[len(stack)-1]; parent.keep {
+				n := &html.Node{Type: html.TextNode, Data: tok.Data}
+				if tt == html.CommentToken {
+					n.Type = html.CommentNode
+				}
+				parent.node.AppendChild(n)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const streamTestHTML = `<!DOCTYPE html>
+<html><head><title>Stream</title></head><body>
+<div id="a" class="x"><p>one<p>two <a href="/1">1</a></div>
+<ul><li>a<li class="x">b<ul><li>c</ul><li>d</ul>
+<table><tr><td>1<td>2<tr><td><a href="/3">3</a></table>
+<div class="x y"><div class="x">nested</div></div>
+</body></html>`
+
+// Display the nodes a query selects from a document, as queried from the
+// tree and as streamed
+func treeAndStream(t *testing.T, input, query string) (string, string) {
+	cmds, err := ParseCommands(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, ok := parseStreamSelector(cmds)
+	if !ok {
+		t.Fatalf("%s: expected a streamable selector", query)
+	}
+
+	var tree, stream bytes.Buffer
+	root, err := ParseHTML(strings.NewReader(input), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := SelectNodes(root, selectorFuncs)
+	for _, node := range nodes {
+		TreeDisplayer{}.Display(&tree, []*html.Node{node})
+	}
+	if err := streamHTML(&stream, strings.NewReader(input), docSource{}, sel, selectorFuncs); err != nil {
+		t.Fatal(err)
+	}
+	return tree.String(), stream.String()
+}
+
+func TestStreamMatchesTree(t *testing.T) {
+	queries := []string{
+		"p", "li", "td", "tr > td a", "ul > li", "div", ".x", "div .x",
+		"#a a", "table tbody tr", "body > div", "li , a", "[class~=y]",
+	}
+	for _, query := range queries {
+		tree, stream := treeAndStream(t, streamTestHTML, query)
+		if !strings.Contains(query, ",") && tree != stream {
+			t.Errorf("%s: expected\n%s\ngot\n%s", query, tree, stream)
+		}
+		if len(tree) != len(stream) {
+			t.Errorf("%s: expected %d bytes of output got %d", query, len(tree), len(stream))
+		}
+	}
+
+	for _, query := range []string{"li:first-child", "h1 + p", "p, *"} {
+		cmds, _ := ParseCommands(query)
+		if _, ok := parseStreamSelector(cmds); ok {
+			t.Errorf("%s: expected selector not to be streamable", query)
+		}
+	}
+}
+
+// The parser implies <html>, <head> and <body> when their tags are left out,
+// so selectors naming them select the same nodes when streaming
+func TestStreamImpliedDocument(t *testing.T) {
+	inputs := []string{
+		`<title>t</title><p>a <a href=x>l</a>`,
+		"hello <b>x</b>\n<p>a",
+		`<!DOCTYPE html><meta charset=utf-8><link rel=icon href=i.png><p>a</p>`,
+		"<html lang=en>\n<p>a</p></html><p>after",
+		"<head><title>t</title></head>\n<p>a</p></body>\n<p>b",
+		"<body class=x><p>a<body class=y><head><title>t</title><p>b",
+		"<!-- c -->\n<html><head>\n<script>x</script>\n<style>p {}</style></head><body><p>a<p>b</body></html>",
+	}
+	queries := []string{
+		"html", "head", "body", "body a", "body p", "html > body > p", "head > title",
+		"html > head > meta", "head link", "head script", "body > b", "[lang] p", ".x p",
+	}
+	for _, input := range inputs {
+		for _, query := range queries {
+			if tree, stream := treeAndStream(t, input, query); tree != stream {
+				t.Errorf("%q %s: expected\n%s\ngot\n%s", input, query, tree, stream)
+			}
+		}
+	}
+}
+
+// Nodes are printed as soon as they close, before the rest of the input has
+// been written, whether or not other jobs are running
+func TestStreamEarlyOutput(t *testing.T) {
+	defer func(d Displayer) { pupDisplayer = d }(pupDisplayer)
+	pupDisplayer = TextDisplayer{}
+	cmds, _ := ParseCommands("p")
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, _ := parseStreamSelector(cmds)
+	for _, n := range []int{1, 4} {
+		input, inputWriter := io.Pipe()
+		output, outputWriter := io.Pipe()
+		jobs := make(chan queryJob, 1)
+		jobs <- func(w io.Writer) error {
+			return streamHTML(w, input, docSource{}, sel, selectorFuncs)
+		}
+		close(jobs)
+		errs := runJobs(jobs, n, outputWriter)
+		lines := make(chan string)
+		go func() {
+			r := bufio.NewReader(output)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					close(lines)
+					return
+				}
+				lines <- line
+			}
+		}()
+
+		go inputWriter.Write([]byte("<p>a</p>"))
+		select {
+		case line := <-lines:
+			if line != "a\n" {
+				t.Errorf("-j %d: expected a got %q", n, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("-j %d: nothing was printed before the input was closed", n)
+		}
+		go func() {
+			inputWriter.Write([]byte("<p>b</p>"))
+			inputWriter.Close()
+		}()
+		if line := <-lines; line != "b\n" {
+			t.Errorf("-j %d: expected b got %q", n, line)
+		}
+		if err := <-errs; err != nil {
+			t.Errorf("-j %d: %s", n, err)
+		}
+		outputWriter.Close()
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Print the result of executing a text/template for each node
+//
+// The node is passed to the template as dot and the following helpers are
+// available. Helpers which act on a node use the current node unless one
+// is piped in, e.g. `{{ query ".price" | text }}`.
+//
+//	attr "key"    value of an attribute
+//	text          text of the node and all of its children
+//	html          the node rendered as HTML
+//	query "sel"   nodes below the node matching a selector
+//	index         position of the node in the selection, starting at 0
+//	abs "url"     a URL resolved against the document's base URL
+type TemplateDisplayer struct {
+	Template *template.Template
+}
+
+// Parse the body of a tmpl{...} display function or a --template-file
+func ParseTemplate(text string) (TemplateDisplayer, error) {
+	t, err := template.New("tmpl").Funcs(templateFuncs(nil, 0)).Parse(text)
+	if err != nil {
+		return TemplateDisplayer{}, err
+	}
+	return TemplateDisplayer{Template: t}, nil
+}
+
+func (t TemplateDisplayer) Display(w io.Writer, nodes []*html.Node) {
+	if err := t.Execute(w, nodes); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	}
+}
+
+// Execute the template for each node, stopping at the first which fails.
+// The error is returned rather than exiting, so a job which fails doesn't
+// stop the others and main decides the exit status once they're done.
+func (t TemplateDisplayer) Execute(w io.Writer, nodes []*html.Node) error {
+	// helpers are bound to the current node, so work on a copy
+	tmpl, err := t.Template.Clone()
+	if err != nil {
+		return fmt.Errorf("Template error: %s", err.Error())
+	}
+	var buf bytes.Buffer
+	for i, node := range nodes {
+		buf.Reset()
+		tmpl.Funcs(templateFuncs(node, i))
+		if err := tmpl.Execute(&buf, node); err != nil {
+			return fmt.Errorf("Template error: %s", err.Error())
+		}
+		// each node gets at least one line of output
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		w.Write(buf.Bytes())
+	}
+	return nil
+}
+
+// Helpers available to templates. These shadow the text/template builtins
+// of the same name.
+func templateFuncs(node *html.Node, index int) template.FuncMap {
+	return template.FuncMap{
+		"attr": func(key string, args ...interface{}) (string, error) {
+			nodes, err := templateNodes(node, args)
+			if err != nil {
+				return "", err
+			}
+			for _, n := range nodes {
+				for _, attr := range n.Attr {
+					if attr.Key == key {
+						if pupEscapeHTML {
+							return html.EscapeString(attr.Val), nil
+						}
+						return attr.Val, nil
+					}
+				}
+			}
+			return "", nil
+		},
+		"text": func(args ...interface{}) (string, error) {
+			nodes, err := templateNodes(node, args)
+			if err != nil {
+				return "", err
+			}
+			texts := []string{}
+			for _, n := range nodes {
+				var buf bytes.Buffer
+				writeText(&buf, n)
+				texts = append(texts, strings.Fields(buf.String())...)
+			}
+			return strings.Join(texts, " "), nil
+		},
+		"html": func(args ...interface{}) (string, error) {
+			nodes, err := templateNodes(node, args)
+			if err != nil {
+				return "", err
+			}
+			var buf bytes.Buffer
+			for _, n := range nodes {
+				if err := html.Render(&buf, n); err != nil {
+					return "", err
+				}
+			}
+			return buf.String(), nil
+		},
+		"query": func(sel string, args ...interface{}) ([]*html.Node, error) {
+			nodes, err := templateNodes(node, args)
+			if err != nil {
+				return nil, err
+			}
+			cmds, err := ParseCommands(sel)
+			if err != nil {
+				return nil, err
+			}
+			selectorFuncs, err := ParseSelectorFuncs(cmds)
+			if err != nil {
+				return nil, err
+			}
+			selected := []*html.Node{}
+			for _, n := range nodes {
+				selected = append(selected, SelectNodes(n, selectorFuncs)...)
+			}
+			return selected, nil
+		},
+		"abs": func(ref string) string {
+			return resolveURL(node, ref)
+		},
+		"index": func() int {
+			return index
+		},
+	}
+}
+
+// Determine which nodes a template helper should act on
+func templateNodes(node *html.Node, args []interface{}) ([]*html.Node, error) {
+	switch len(args) {
+	case 0:
+		return []*html.Node{node}, nil
+	case 1:
+		switch arg := args[0].(type) {
+		case *html.Node:
+			return []*html.Node{arg}, nil
+		case []*html.Node:
+			return arg, nil
+		}
+		return nil, fmt.Errorf("expected a node, got %T", args[0])
+	}
+	return nil, fmt.Errorf("expected at most one node, got %d arguments", len(args))
+}
+
+// Write the text of a node and all of its children
+func writeText(buf *bytes.Buffer, n *html.Node) {
+	if n.Type == html.TextNode {
+		data := n.Data
+		if pupEscapeHTML {
+			// don't escape javascript
+			if n.Parent == nil || n.Parent.DataAtom != atom.Script {
+				data = html.EscapeString(data)
+			}
+		}
+		buf.WriteString(data)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeText(buf, c)
+	}
+}
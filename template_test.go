@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// A template which fails fails its job, keeping the output before it, and
+// the other jobs still run
+func TestTemplateError(t *testing.T) {
+	defer func(d Displayer) { pupDisplayer = d }(pupDisplayer)
+	d, err := ParseTemplate(`{{ if eq (text) "b" }}{{ index . 1 }}{{ end }}{{ text }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pupDisplayer = d
+	cmds, _ := ParseCommands("p")
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []string{"<p>a<p>b<p>c", "<p>d"}
+	jobs := make(chan queryJob, len(inputs))
+	for _, input := range inputs {
+		input := input
+		jobs <- func(w io.Writer) error {
+			root, err := html.Parse(strings.NewReader(input))
+			if err != nil {
+				return err
+			}
+			return queryDocument(w, root, docSource{}, selectorFuncs)
+		}
+	}
+	close(jobs)
+	var out bytes.Buffer
+	errs := []error{}
+	for err := range runJobs(jobs, 2, &out) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 results got %d", len(errs))
+	}
+	if out.String() != "a\nd\n" {
+		t.Errorf("expected a from the first job and d from the second, got %q", out.String())
+	}
+	if errs[0] == nil || !strings.HasPrefix(errs[0].Error(), "Template error:") {
+		t.Errorf("expected the first job to fail, got %v", errs[0])
+	}
+	if errs[1] != nil {
+		t.Errorf("expected the second job to succeed, got %v", errs[1])
+	}
+}
+
+// Streamed nodes stop at the first the template fails for
+func TestTemplateStreamError(t *testing.T) {
+	defer func(d Displayer) { pupDisplayer = d }(pupDisplayer)
+	d, err := ParseTemplate(`{{ if eq (text) "b" }}{{ index . 1 }}{{ end }}{{ text }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pupDisplayer = d
+	cmds, _ := ParseCommands("p")
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, ok := parseStreamSelector(cmds)
+	if !ok {
+		t.Fatal("expected a streamable selector")
+	}
+	var out bytes.Buffer
+	err = streamHTML(&out, strings.NewReader("<p>a<p>b<p>c"), docSource{}, sel, selectorFuncs)
+	if err == nil || !strings.HasPrefix(err.Error(), "Template error:") {
+		t.Errorf("expected a template error, got %v", err)
+	}
+	if out.String() != "a\n" {
+		t.Errorf("expected a before the error, got %q", out.String())
+	}
+}
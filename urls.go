@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Attributes which hold a URL, or a list of them in the case of srcset
+var linkAttrs = map[string]bool{
+	"action": true,
+	"cite":   true,
+	"data":   true,
+	"href":   true,
+	"poster": true,
+	"src":    true,
+	"srcset": true,
+}
+
+var (
+	baseCacheMu sync.Mutex
+	baseCache   = map[*html.Node]*url.URL{}
+)
+
+// The URL relative references in a node's document resolve against. This is
+// the document's <base href> resolved against the URL the document came from,
+// or the location of its MHTML archive, or --base-url, or nil if none are
+// known.
+func baseURL(n *html.Node) *url.URL {
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	baseCacheMu.Lock()
+	defer baseCacheMu.Unlock()
+	if base, ok := baseCache[root]; ok {
+		return base
+	}
+	base := pupBaseURL
+	if u, err := url.Parse(lookupSource(root).URL); err == nil && u.IsAbs() {
+		base = u
+	}
+	if archive := documentArchive(root); archive != nil && archive.Location != nil {
+		base = archive.Location
+	}
+	if href, ok := findBaseHref(root); ok {
+		if u, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			if base != nil {
+				u = base.ResolveReference(u)
+			}
+			base = u
+		}
+	}
+	baseCache[root] = base
+	return base
+}
+
+// Find the href of the first <base> element with one
+func findBaseHref(n *html.Node) (string, bool) {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Base {
+		if href, ok := getAttr(n, "href"); ok {
+			return href, true
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if href, ok := findBaseHref(c); ok {
+			return href, true
+		}
+	}
+	return "", false
+}
+
+// Resolve a reference against the base URL of a node's document. The
+// reference is returned as is if there's no base URL or it can't be parsed.
+// cid: references to the parts of an MHTML archive resolve to their location.
+func resolveURL(n *html.Node, ref string) string {
+	if archive := documentArchive(n); archive != nil && strings.HasPrefix(ref, "cid:") {
+		if location, ok := archive.Parts[strings.TrimPrefix(ref, "cid:")]; ok {
+			return location
+		}
+	}
+	base := baseURL(n)
+	if base == nil {
+		return ref
+	}
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// The value of an attribute, with URLs resolved if --base-url was given,
+// force is set or the node is in an MHTML archive read with
+// --mhtml-locations, whose references are to its parts
+func resolveAttr(n *html.Node, attr html.Attribute, force bool) string {
+	if attr.Namespace != "" || !linkAttrs[attr.Key] || !(force || pupBaseURL != nil || documentArchive(n) != nil) {
+		return attr.Val
+	}
+	if attr.Key == "srcset" {
+		candidates := parseSrcset(attr.Val)
+		for i, c := range candidates {
+			candidates[i].URL = resolveURL(n, c.URL)
+		}
+		return formatSrcset(candidates)
+	}
+	return resolveURL(n, attr.Val)
+}
+
+// An image candidate of a srcset attribute, e.g. `image.png 2x`
+type srcsetCandidate struct {
+	URL        string
+	Descriptor string
+}
+
+// Parse a srcset attribute. URLs may contain commas, so a candidate ends at
+// a comma following the URL or its descriptors.
+func parseSrcset(s string) []srcsetCandidate {
+	candidates := []srcsetCandidate{}
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f,")
+		if s == "" {
+			return candidates
+		}
+		end := strings.IndexAny(s, " \t\n\r\f")
+		if end < 0 {
+			end = len(s)
+		}
+		c := srcsetCandidate{URL: s[:end]}
+		s = s[end:]
+		if strings.HasSuffix(c.URL, ",") {
+			c.URL = strings.TrimRight(c.URL, ",")
+		} else {
+			end = strings.IndexRune(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			c.Descriptor = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		candidates = append(candidates, c)
+	}
+}
+
+func formatSrcset(candidates []srcsetCandidate) string {
+	parts := []string{}
+	for _, c := range candidates {
+		if c.Descriptor != "" {
+			parts = append(parts, c.URL+" "+c.Descriptor)
+		} else {
+			parts = append(parts, c.URL)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+type srcsetTest struct {
+	input      string
+	candidates []srcsetCandidate
+}
+
+var srcsetTests = []srcsetTest{
+	srcsetTest{`a.png`, []srcsetCandidate{{"a.png", ""}}},
+	srcsetTest{`a.png 1x, b.png 2x`, []srcsetCandidate{{"a.png", "1x"}, {"b.png", "2x"}}},
+	srcsetTest{` a.png, b.png 100w `, []srcsetCandidate{{"a.png", ""}, {"b.png", "100w"}}},
+	srcsetTest{`a.png,b.png`, []srcsetCandidate{{"a.png,b.png", ""}}},
+	srcsetTest{`data:image/png;base64,AA 2x`, []srcsetCandidate{{"data:image/png;base64,AA", "2x"}}},
+	srcsetTest{``, []srcsetCandidate{}},
+}
+
+func TestParseSrcset(t *testing.T) {
+	for _, test := range srcsetTests {
+		candidates := parseSrcset(test.input)
+		if len(candidates) != len(test.candidates) {
+			t.Errorf("`%s`: expected %v got %v", test.input, test.candidates, candidates)
+			continue
+		}
+		for i := range candidates {
+			if candidates[i] != test.candidates[i] {
+				t.Errorf("`%s`: expected %v got %v", test.input, test.candidates, candidates)
+			}
+		}
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	doc := `<html><head><base href="/docs/"></head><body><a href="../a">a</a></body></html>`
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := root.LastChild.LastChild.FirstChild
+	if got := resolveURL(a, "../a"); got != "/a" {
+		t.Errorf("expected /a got %s", got)
+	}
+	if got := resolveURL(a, "img/b.png"); got != "/docs/img/b.png" {
+		t.Errorf("expected /docs/img/b.png got %s", got)
+	}
+}
+
+// A <base> only changes the URLs printed when they're resolved, by abs{} or
+// with --base-url
+func TestResolveAttr(t *testing.T) {
+	defer func(base *url.URL) { pupBaseURL = base }(pupBaseURL)
+	withBase := `<base href="https://example.com/docs/"><a href="../a">a</a><img srcset="b.png 2x">`
+	tests := []struct {
+		input    string
+		force    bool
+		baseURL  string
+		expected string
+	}{
+		{withBase, false, "", "../a\nb.png 2x\n"},
+		{withBase, true, "", "https://example.com/a\nhttps://example.com/docs/b.png 2x\n"},
+		{withBase, false, "https://other.example/", "https://example.com/a\nhttps://example.com/docs/b.png 2x\n"},
+		{`<a href="../a">a</a><img srcset="b.png 2x">`, true, "", "../a\nb.png 2x\n"},
+		{`<a href="../a">a</a><img srcset="b.png 2x">`, false, "https://other.example/x/y/", "https://other.example/x/a\nhttps://other.example/x/y/b.png 2x\n"},
+	}
+	cmds, _ := ParseCommands("a, img")
+	selectorFuncs, err := ParseSelectorFuncs(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		pupBaseURL = nil
+		if test.baseURL != "" {
+			if pupBaseURL, err = url.Parse(test.baseURL); err != nil {
+				t.Fatal(err)
+			}
+		}
+		root, err := html.Parse(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := ""
+		for _, n := range SelectNodes(root, selectorFuncs) {
+			for _, a := range n.Attr {
+				output += resolveAttr(n, a, test.force) + "\n"
+			}
+		}
+		if output != test.expected {
+			t.Errorf("%s: expected %q got %q", test.input, test.expected, output)
+		}
+	}
+}
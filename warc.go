@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A record of a WARC file. Block holds Content-Length bytes and must be read
+// or discarded before the next record.
+type warcRecord struct {
+	Header textproto.MIMEHeader
+	Block  io.Reader
+}
+
+// Reads the records of a WARC file, see
+// https://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/
+type warcReader struct {
+	r     *bufio.Reader
+	block io.Reader
+}
+
+func newWarcReader(r io.Reader) *warcReader {
+	return &warcReader{r: bufio.NewReader(r)}
+}
+
+// Read the next record, returning io.EOF after the last one
+func (wr *warcReader) Next() (*warcRecord, error) {
+	if wr.block != nil {
+		if _, err := io.Copy(ioutil.Discard, wr.block); err != nil {
+			return nil, err
+		}
+	}
+	// records are separated by blank lines
+	var version string
+	for version == "" {
+		line, err := wr.r.ReadString('\n')
+		version = strings.TrimRight(line, "\r\n")
+		if err == io.EOF && version == "" {
+			return nil, io.EOF
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("Invalid WARC record '%s'", version)
+	}
+	header, err := textproto.NewReader(wr.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid WARC record header: %s", err.Error())
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("Invalid WARC record length '%s'", header.Get("Content-Length"))
+	}
+	wr.block = io.LimitReader(wr.r, length)
+	return &warcRecord{Header: header, Block: wr.block}, nil
+}
+
+// Queue a job for each HTML response of a WARC file which matches --warc-url
+func queueWarc(jobs chan<- queryJob, name string, selectorFuncs []SelectorFunc) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompress(f, pupDecompress)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	wr := newWarcReader(r)
+	for {
+		record, err := wr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if record.Header.Get("WARC-Type") != "response" {
+			continue
+		}
+		src := docSource{
+			File: name,
+			URL:  strings.Trim(record.Header.Get("WARC-Target-URI"), "<>"),
+			Date: record.Header.Get("WARC-Date"),
+		}
+		if pupWarcURL != nil && !pupWarcURL.MatchString(src.URL) {
+			continue
+		}
+		body, declared, ok, err := readWarcResponse(record)
+		if !ok {
+			continue
+		}
+		jobs <- func(w io.Writer) error {
+			if err != nil {
+				return fmt.Errorf("%s: %s", src.URL, err.Error())
+			}
+			if pupLint != "" {
+				return lintDecoded(w, bytes.NewReader(body), src, pupCharset, declared)
+			}
+			root, err := parseDecoded(bytes.NewReader(body), pupCharset, declared)
+			if err != nil {
+				return fmt.Errorf("%s: %s", src.URL, err.Error())
+			}
+			if err := queryDocument(w, root, src, selectorFuncs); err != nil {
+				return fmt.Errorf("%s: %s", src.URL, err.Error())
+			}
+			return nil
+		}
+	}
+}
+
+// Read the decoded body of an HTTP response record and the charset it
+// declares. ok is false for records which aren't HTML responses.
+func readWarcResponse(record *warcRecord) (body []byte, declared string, ok bool, err error) {
+	typ, _, _ := mime.ParseMediaType(record.Header.Get("Content-Type"))
+	if typ != "application/http" {
+		return nil, "", false, nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(record.Block), nil)
+	if err != nil {
+		return nil, "", true, err
+	}
+	defer resp.Body.Close()
+	typ, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if typ != "text/html" {
+		return nil, "", false, nil
+	}
+	r, err := contentDecoder(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, "", true, err
+	}
+	defer r.Close()
+	body, err = ioutil.ReadAll(r)
+	return body, params["charset"], true, err
+}
+
+// Decode an HTTP Content-Encoding. Transfer encodings such as chunked are
+// already handled by net/http.
+func contentDecoder(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return ioutil.NopCloser(r), nil
+	case "gzip", "x-gzip":
+		return decompress(r, "gzip")
+	case "br":
+		return decompress(r, "brotli")
+	case "zstd":
+		return decompress(r, "zstd")
+	case "deflate":
+		// deflate is meant to be zlib wrapped but is often sent raw
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && (uint(header[0])<<8|uint(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("Unsupported content encoding '%s'", encoding)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func warcRecordString(typ, uri, contentType, block string) string {
+	return fmt.Sprintf("WARC/1.0\r\nWARC-Type: %s\r\nWARC-Target-URI: %s\r\n"+
+		"WARC-Date: 2016-07-20T08:41:39Z\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n",
+		typ, uri, contentType, len(block), block)
+}
+
+func TestWarcReader(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	io.WriteString(w, "<p>caf\xe9</p>")
+	w.Close()
+	chunked := fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", gz.Len(), gz.String())
+
+	warc := warcRecordString("warcinfo", "", "application/warc-fields", "software: test\r\n") +
+		warcRecordString("request", "http://example.com/", "application/http; msgtype=request",
+			"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n") +
+		warcRecordString("response", "<http://example.com/>", "application/http; msgtype=response",
+			"HTTP/1.1 200 OK\r\nContent-Type: text/html; charset=ISO-8859-1\r\n"+
+				"Content-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n"+chunked) +
+		warcRecordString("response", "http://example.com/a.png", "application/http; msgtype=response",
+			"HTTP/1.1 200 OK\r\nContent-Type: image/png\r\n\r\nPNG")
+
+	wr := newWarcReader(strings.NewReader(warc))
+	bodies := []string{}
+	for {
+		record, err := wr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if record.Header.Get("WARC-Type") != "response" {
+			continue
+		}
+		body, declared, ok, err := readWarcResponse(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			if declared != "ISO-8859-1" {
+				t.Errorf("expected charset ISO-8859-1 got %s", declared)
+			}
+			bodies = append(bodies, string(body))
+		}
+	}
+	if len(bodies) != 1 || bodies[0] != "<p>caf\xe9</p>" {
+		t.Errorf("expected one HTML response got %q", bodies)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Nodes of an XML document which html.Node has no type for. CDATA sections
+// are held by text nodes and processing instructions by comment nodes.
+type xmlDocument struct {
+	CDATA     map[*html.Node]bool
+	ProcInsts map[*html.Node]bool
+}
+
+var (
+	xmlDocumentsMu sync.Mutex
+	xmlDocuments   = map[*html.Node]*xmlDocument{}
+)
+
+// The XML document a node belongs to, or nil if it was parsed as HTML
+func documentXML(n *html.Node) *xmlDocument {
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	xmlDocumentsMu.Lock()
+	defer xmlDocumentsMu.Unlock()
+	return xmlDocuments[root]
+}
+
+// Is a text node a CDATA section?
+func isCDATA(n *html.Node) bool {
+	if n.Type != html.TextNode {
+		return false
+	}
+	doc := documentXML(n)
+	return doc != nil && doc.CDATA[n]
+}
+
+// Is a comment node a processing instruction?
+func isProcInst(n *html.Node) bool {
+	if n.Type != html.CommentNode {
+		return false
+	}
+	doc := documentXML(n)
+	return doc != nil && doc.ProcInsts[n]
+}
+
+var xmlEncodingRe = regexp.MustCompile(`^<\?xml[^>]*\sencoding\s*=\s*["']([^"']+)["']`)
+
+// Determine the encoding of an XML document: the one given with --charset,
+// then a Content-Type, a byte order mark, the XML declaration and UTF-8
+func xmlCharset(content []byte, cs, declared string) charsetInfo {
+	content = bytes.TrimPrefix(content, []byte{0xef, 0xbb, 0xbf})
+	switch {
+	case cs != "":
+		return charsetInfo{Name: cs, Source: "flag"}
+	case declared != "":
+		return charsetInfo{Name: declared, Source: "content-type"}
+	case bomCharset(content) != "":
+		return charsetInfo{Name: bomCharset(content), Source: "bom"}
+	}
+	if match := xmlEncodingRe.FindSubmatch(content); match != nil {
+		return charsetInfo{Name: string(match[1]), Source: "declaration"}
+	}
+	return charsetInfo{Name: "utf-8", Source: "default"}
+}
+
+// Build a tree from XML, keeping the case and namespace prefix of names,
+// e.g. <atom:link> or <pubDate>, so the selectors and displayers work on
+// feeds, sitemaps and SVG as they do on HTML. Documents are read into memory
+// to find which text was written as CDATA.
+func parseXML(r io.Reader, cs, declared string) (*html.Node, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	info := xmlCharset(content, cs, declared)
+	e, name := charset.Lookup(info.Name)
+	if e == nil {
+		return nil, fmt.Errorf("'%s' is not a valid charset", info.Name)
+	}
+	info.Name = name
+	if content, err = e.NewDecoder().Bytes(content); err != nil {
+		return nil, err
+	}
+	content = bytes.TrimPrefix(content, []byte{0xef, 0xbb, 0xbf})
+
+	root := &html.Node{Type: html.DocumentNode}
+	doc := &xmlDocument{CDATA: map[*html.Node]bool{}, ProcInsts: map[*html.Node]bool{}}
+	d := xml.NewDecoder(bytes.NewReader(content))
+	d.Entity = xml.HTMLEntity
+	// the content has already been converted to UTF-8
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var positions map[*html.Node]sourcePos
+	if trackPositions() {
+		positions = map[*html.Node]sourcePos{}
+	}
+	pos := startPos
+	open := root
+	for {
+		offset := d.InputOffset()
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &html.Node{Type: html.ElementNode, Data: xmlName(tok.Name)}
+			if positions != nil {
+				pos.advance(content[pos.Offset:offset])
+				positions[n] = pos
+			}
+			for _, a := range tok.Attr {
+				n.Attr = append(n.Attr, html.Attribute{Namespace: a.Name.Space, Key: a.Name.Local, Val: a.Value})
+			}
+			open.AppendChild(n)
+			open = n
+		case xml.EndElement:
+			if open == root {
+				return nil, fmt.Errorf("unexpected end element </%s>", xmlName(tok.Name))
+			}
+			if open.Data != xmlName(tok.Name) {
+				return nil, fmt.Errorf("element <%s> closed by </%s>", open.Data, xmlName(tok.Name))
+			}
+			open = open.Parent
+		case xml.CharData:
+			n := &html.Node{Type: html.TextNode, Data: string(tok)}
+			if bytes.HasPrefix(content[offset:], []byte("<![CDATA[")) {
+				doc.CDATA[n] = true
+			}
+			open.AppendChild(n)
+		case xml.Comment:
+			open.AppendChild(&html.Node{Type: html.CommentNode, Data: string(tok)})
+		case xml.ProcInst:
+			n := &html.Node{Type: html.CommentNode, Data: tok.Target}
+			if len(tok.Inst) > 0 {
+				n.Data += " " + string(tok.Inst)
+			}
+			doc.ProcInsts[n] = true
+			open.AppendChild(n)
+		case xml.Directive:
+			if bytes.HasPrefix(tok, []byte("DOCTYPE")) {
+				data := bytes.TrimSpace(bytes.TrimPrefix(tok, []byte("DOCTYPE")))
+				open.AppendChild(&html.Node{Type: html.DoctypeNode, Data: string(data)})
+			}
+		}
+	}
+	if open != root {
+		return nil, fmt.Errorf("unclosed element <%s>", open.Data)
+	}
+
+	xmlDocumentsMu.Lock()
+	xmlDocuments[root] = doc
+	xmlDocumentsMu.Unlock()
+	setDocumentCharset(root, info)
+	if positions != nil {
+		setDocumentPositions(root, positions)
+	}
+	return root, nil
+}
+
+// The name of an element as written, e.g. atom:link
+func xmlName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// Serialize a node as XML. Elements without children are self-closing and
+// text is only escaped if pupEscapeHTML is set, except for CDATA sections
+// which are written as they were.
+func writeXML(buf *bytes.Buffer, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if isCDATA(n) {
+			buf.WriteString("<![CDATA[" + n.Data + "]]>")
+		} else if pupEscapeHTML {
+			buf.WriteString(html.EscapeString(n.Data))
+		} else {
+			buf.WriteString(n.Data)
+		}
+	case html.CommentNode:
+		if isProcInst(n) {
+			buf.WriteString("<?" + n.Data + "?>")
+		} else {
+			buf.WriteString("<!--" + n.Data + "-->")
+		}
+	case html.DoctypeNode:
+		buf.WriteString("<!DOCTYPE " + n.Data + ">")
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeXML(buf, c)
+		}
+	case html.ElementNode:
+		buf.WriteString("<" + n.Data)
+		for _, a := range n.Attr {
+			buf.WriteString(" " + attrName(a) + `="` + html.EscapeString(a.Val) + `"`)
+		}
+		if n.FirstChild == nil {
+			buf.WriteString("/>")
+			return
+		}
+		buf.WriteString(">")
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeXML(buf, c)
+		}
+		buf.WriteString("</" + n.Data + ">")
+	}
+}
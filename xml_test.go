@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testXML = `<?xml version="1.0"?>
+<rss xmlns:atom="http://www.w3.org/2005/Atom"><channel>` +
+	`<atom:link href="/feed" rel="self"/><item><pubDate>Tue</pubDate>` +
+	`<description><![CDATA[<p>a & b</p>]]></description><guid/></item></channel></rss>`
+
+func TestParseXML(t *testing.T) {
+	root, err := parseXML(strings.NewReader(testXML), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseDocument(root)
+
+	for _, query := range []string{"pubDate", "atom|link", "item > guid", "description"} {
+		cmds, err := ParseCommands(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selectorFuncs, err := ParseSelectorFuncs(cmds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes := SelectNodes(root, selectorFuncs); len(nodes) != 1 {
+			t.Errorf("%s: expected 1 node got %d", query, len(nodes))
+		}
+	}
+
+	var buf bytes.Buffer
+	writeXML(&buf, root)
+	if buf.String() != testXML {
+		t.Errorf("expected\n%s\ngot\n%s", testXML, buf.String())
+	}
+
+	if _, err := parseXML(strings.NewReader("<a><b></a>"), "", ""); err == nil {
+		t.Error("expected mismatched elements to be an error")
+	}
+}